@@ -0,0 +1,54 @@
+package main
+
+import (
+	"dblyzer/internal/logger"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type reloader interface {
+	Reload() error
+	RulesVersion() string
+}
+
+// reload the rules on SIGHUP
+func reloadOnHangup(db reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			db.Reload()
+		}
+	}()
+}
+
+func serveAdmin(addr string, db reloader) {
+	handler := adminHandler(db)
+	go func() {
+		if err := http.ListenAndServe(addr, handler); err != nil {
+			logger.Error("admin endpoint failed", "addr", addr, "err", err)
+		}
+	}()
+}
+
+// POST /reload reloads the rules and answers with the version in use
+func adminHandler(db reloader) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := db.Reload(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error(), "rules": db.RulesVersion()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"rules": db.RulesVersion()})
+	})
+	return mux
+}
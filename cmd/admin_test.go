@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeReloader bumps its version on every successful reload
+type fakeReloader struct {
+	mu      sync.Mutex
+	err     error
+	reloads int
+}
+
+func (f *fakeReloader) Reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.reloads += 1
+	return nil
+}
+
+func (f *fakeReloader) RulesVersion() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return string(rune('a' + f.reloads))
+}
+
+func (f *fakeReloader) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reloads
+}
+
+func TestAdminReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		err     error
+		status  int
+		body    map[string]string
+		reloads int
+	}{
+		{"post", http.MethodPost, nil, http.StatusOK, map[string]string{"rules": "b"}, 1},
+		{"get", http.MethodGet, nil, http.StatusMethodNotAllowed, nil, 0},
+		{"failed", http.MethodPost, errors.New("bad rules"), http.StatusInternalServerError,
+			map[string]string{"rules": "a", "error": "bad rules"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeReloader{err: tt.err}
+			srv := httptest.NewServer(adminHandler(db))
+			defer srv.Close()
+
+			req, err := http.NewRequest(tt.method, srv.URL+"/reload", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if db.count() != tt.reloads {
+				t.Errorf("%d reloads, want %d", db.count(), tt.reloads)
+			}
+			if tt.body == nil {
+				return
+			}
+			var body map[string]string
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body) != len(tt.body) || body["rules"] != tt.body["rules"] || body["error"] != tt.body["error"] {
+				t.Errorf("body %v, want %v", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	db := &fakeReloader{}
+	reloadOnHangup(db)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for db.count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no reload after SIGHUP")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"dblyzer/internal/dbio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// dblyzer diff [-json] old.txt new.txt
+func diffReports(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print changes as json lines")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: dblyzer diff [-json] old.txt new.txt")
+		return 2
+	}
+	old, err := dbio.ReadReports(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", fs.Arg(0), err)
+		return 2
+	}
+	new, err := dbio.ReadReports(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", fs.Arg(1), err)
+		return 2
+	}
+
+	changes := dbio.Diff(old, new)
+	for _, c := range changes {
+		if *asJSON {
+			b, _ := json.Marshal(c)
+			fmt.Println(string(b))
+		} else {
+			fmt.Println(c.String())
+		}
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
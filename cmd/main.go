@@ -1,15 +1,82 @@
 package main
 
 import (
+	"context"
 	"dblyzer"
 	"dblyzer/internal/config"
 	"dblyzer/internal/dbio"
+	"dblyzer/internal/logger"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	recvChan := dbio.NewRecv()
-	rpChan := dbio.NewRp()
-	db := dblyzer.New("./apps.json", recvChan, rpChan)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate-rules":
+			os.Exit(validateRules(os.Args[2:]))
+		case "diff":
+			os.Exit(diffReports(os.Args[2:]))
+		case "report":
+			os.Exit(formatReport(os.Args[2:]))
+		case "query":
+			os.Exit(query(os.Args[2:]))
+		}
+	}
+
+	if err := config.Load("config.yaml"); err != nil {
+		panic("Need config.yaml")
+	}
+	if err := logger.Configure(config.Conf.LogLevel, config.Conf.LogFormat, config.Conf.LogFile); err != nil {
+		panic(err.Error())
+	}
+	ckptPath := ""
+	if config.Conf.Checkpoint {
+		ckptPath = dbio.CheckpointPath(config.Conf.OutputFile)
+	}
+	ckpt, err := dbio.OpenCheckpoint(ckptPath)
+	if err != nil {
+		panic(err.Error())
+	}
+	if n := ckpt.Len(); n > 0 {
+		logger.Info("resuming from checkpoint", "done", n, "file", ckptPath)
+	}
+	// on SIGINT/SIGTERM stop reading targets and finish the queued ones, a
+	// second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	recvChan, recvErr := dbio.NewRecv(ctx)
+	recvChan = ckpt.Filter(recvChan)
+	rpChan, rpDone := dbio.NewRp(ckpt)
+	rules := config.Conf.Rules
+	if len(rules) == 0 {
+		rules = []string{"./apps.json"}
+	}
+	db := dblyzer.NewWithRules(rules, recvChan, rpChan)
+	reloadOnHangup(db)
+	if config.Conf.AdminListen != "" {
+		serveAdmin(config.Conf.AdminListen, db)
+	}
+	if config.Conf.MetricsListen != "" {
+		serveMetrics(config.Conf.MetricsListen, db)
+	}
+	if config.Conf.Progress > 0 {
+		printProgress(time.Duration(config.Conf.Progress)*time.Second, db)
+	}
 	db.Run(config.Conf.Workers)
 	db.Wait()
+	<-rpDone
+	err = <-recvErr
+	if n := ckpt.Skipped(); n > 0 {
+		logger.Info("skipped targets done before or queued twice", "skipped", n)
+	}
+	logger.Close()
+	if err != nil && err != context.Canceled {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"dblyzer"
+	"dblyzer/internal/logger"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+type monitored interface {
+	Status() dblyzer.Status
+	WriteMetrics(w io.Writer)
+	Progress() string
+}
+
+// serveMetrics serves /metrics in the prometheus text format and /status as json
+func serveMetrics(addr string, db monitored) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		db.WriteMetrics(w)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(db.Status())
+	})
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("metrics endpoint failed", "addr", addr, "err", err)
+		}
+	}()
+}
+
+// printProgress writes a progress line to stderr every interval
+func printProgress(interval time.Duration, db monitored) {
+	go func() {
+		for range time.Tick(interval) {
+			fmt.Fprintln(os.Stderr, db.Progress())
+		}
+	}()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"dblyzer/internal/dbio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const queryUsage = `usage: dblyzer query [-db dblyzer.db] [-json] <query>
+  app NAME [-below VERSION]   hosts running NAME, optionally below VERSION
+  favicons                    hosts sharing a favicon hash
+  domains                     domains seen and how many services link them`
+
+// dblyzer query [-db dblyzer.db] [-json] app|favicons|domains ...
+func query(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	path := fs.String("db", "dblyzer.db", "sqlite report database")
+	asJSON := fs.Bool("json", false, "print results as json lines")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, queryUsage) }
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if _, err := os.Stat(*path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	db, err := dbio.OpenDB(*path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer db.Close()
+
+	var rows []interface{}
+	var lines []string
+	switch fs.Arg(0) {
+	case "app":
+		qs := flag.NewFlagSet("app", flag.ExitOnError)
+		below := qs.String("below", "", "only versions lower than this")
+		if fs.NArg() < 2 {
+			fs.Usage()
+			return 2
+		}
+		qs.Parse(fs.Args()[2:])
+		hits, err := dbio.QueryApp(db, fs.Arg(1), *below)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for _, h := range hits {
+			rows = append(rows, h)
+			lines = append(lines, strings.TrimSpace(fmt.Sprintf("%s %s %s %s", h.Target, h.Service, h.App, h.Version)))
+		}
+	case "favicons":
+		groups, err := dbio.QuerySharedFavicons(db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for _, g := range groups {
+			rows = append(rows, g)
+			lines = append(lines, fmt.Sprintf("%s %s", g.Hash, strings.Join(g.Targets, " ")))
+		}
+	case "domains":
+		domains, err := dbio.QueryDomains(db)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for _, d := range domains {
+			rows = append(rows, d)
+			lines = append(lines, fmt.Sprintf("%s %d", d.Domain, d.Services))
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	for i := range rows {
+		if *asJSON {
+			b, _ := json.Marshal(rows[i])
+			fmt.Println(string(b))
+		} else {
+			fmt.Println(lines[i])
+		}
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"dblyzer/internal/dbio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// dblyzer report [-format html] [-o report.html] output.txt
+func formatReport(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "table", "json, text, table, csv, markdown or html")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: dblyzer report [-format html] [-o report.html] output.txt")
+		return 2
+	}
+	f, err := dbio.OpenFormatter(*format, "table")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	reports, err := dbio.ReadReports(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", fs.Arg(0), err)
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	var closer io.Closer
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		w, closer = file, file
+	}
+	sink, err := dbio.NewFormatSink(w, closer, f, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	keys := make([]string, 0, len(reports))
+	for key := range reports {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := sink.Write(reports[key]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if err := sink.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
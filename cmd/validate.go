@@ -0,0 +1,37 @@
+package main
+
+import (
+	"dblyzer"
+	"encoding/json"
+	"flag"
+	"fmt"
+)
+
+// dblyzer validate-rules [-json] [-strict] [-fallback backtrack] [apps.json|technologies dir ...]
+func validateRules(args []string) int {
+	fs := flag.NewFlagSet("validate-rules", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print diagnostics as json lines")
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	fallback := fs.String("fallback", "backtrack", "regex engine for patterns re2 rejects: backtrack, pcre or none")
+	fs.Parse(args)
+
+	sources := fs.Args()
+	if len(sources) == 0 {
+		sources = []string{"./apps.json"}
+	}
+
+	diags := dblyzer.ValidateRules(sources, *fallback)
+	for _, d := range diags {
+		if *asJSON {
+			b, _ := json.Marshal(d)
+			fmt.Println(string(b))
+		} else {
+			fmt.Println(d.String())
+		}
+	}
+
+	if dblyzer.HasErrors(diags) || (*strict && len(diags) > 0) {
+		return 1
+	}
+	return 0
+}
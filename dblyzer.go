@@ -1,29 +1,143 @@
 package dblyzer
 
 import (
+	"context"
 	"dblyzer/internal/dbio"
+	"dblyzer/internal/httpclient"
+	"io"
 )
 
 type dblyzer struct {
-	kill chan int
 	*engine
 }
 
-func New(filePath string, in chan dbio.Receive, out chan dbio.Report) *dblyzer {
+// Sink receives the finished reports. Write and Flush are called from a
+// single goroutine, Flush when no more reports are waiting.
+type Sink = dbio.Sink
+
+// Source yields the targets to scan. Read sends them on out and returns
+// when the source is exhausted, ctx is done or it can't go on.
+type Source = dbio.Source
+
+// SourceFunc adapts a function to Source
+type SourceFunc = dbio.SourceFunc
+
+type Receive = dbio.Receive
+type Report = dbio.Report
+
+// ScanError is why a report has status failed
+type ScanError = dbio.ScanError
+
+// Report.Status values
+const (
+	StatusOK      = dbio.StatusOK
+	StatusNoApps  = dbio.StatusNoApps
+	StatusNotHTTP = dbio.StatusNotHTTP
+	StatusFailed  = dbio.StatusFailed
+	//an http port redirecting to an https endpoint scanned on its own, see Report.Redirect
+	StatusRedirect = dbio.StatusRedirect
+)
+
+// ScanError.Category values, also the keys of Report.Errors
+const (
+	ErrDNS     = httpclient.ErrDNS
+	ErrRefused = httpclient.ErrRefused
+	ErrTimeout = httpclient.ErrTimeout
+	ErrTLS     = httpclient.ErrTLS
+	ErrReset   = httpclient.ErrReset
+	ErrOther   = httpclient.ErrOther
+)
+
+// NewConsoleSink prints one line per report to w
+func NewConsoleSink(w io.Writer) Sink {
+	return dbio.NewConsoleSink(w)
+}
+
+// NewFileSink appends one json line per report to path, the file is opened once
+func NewFileSink(path string) (Sink, error) {
+	return dbio.NewFileSink(path)
+}
+
+// Targets is a Source of a fixed list of targets
+func Targets(list ...Receive) Source {
+	return dbio.Targets(list...)
+}
+
+// FileSource reads a dbgrab output file, gzip compressed files are detected by content
+func FileSource(path string) Source {
+	return dbio.FileSource(path)
+}
+
+// GlobSource reads every file matching pattern, or every file in a directory, in name order
+func GlobSource(pattern string) Source {
+	return dbio.GlobSource(pattern)
+}
+
+// StdinSource reads dbgrab json lines from stdin
+func StdinSource() Source {
+	return dbio.StdinSource()
+}
+
+// RemoteSource listens on addr for connections streaming dbgrab json lines.
+// It runs until ctx is done and returns once every connection is closed.
+func RemoteSource(addr string) Source {
+	return dbio.RemoteSource(addr)
+}
+
+// Feed reads src in the background into a channel New can take as in. The
+// channel is closed when src ends, the error channel then gets Read's error.
+func Feed(ctx context.Context, src Source) (chan Receive, chan error) {
+	return dbio.Feed(ctx, src)
+}
+
+// New scans the targets sent on in. Reports are sent on out when it is not
+// nil and written to every sink. Once in is closed and every target is
+// reported, out is closed and Wait returns.
+func New(filePath string, in chan dbio.Receive, out chan dbio.Report, sinks ...Sink) *dblyzer {
+	return NewWithRules([]string{filePath}, in, out, sinks...)
+}
+
+// NewWithRules layers several rule sources, apps.json files or split
+// technologies directories, later sources override apps of the same name.
+func NewWithRules(sources []string, in chan dbio.Receive, out chan dbio.Report, sinks ...Sink) *dblyzer {
 
 	return &dblyzer{
-		engine: newEngine(filePath, in, out),
-		kill:   make(chan int),
+		engine: newEngine(sources, in, out, sinks),
 	}
 }
 
 func (this *dblyzer) Run(worker int) {
-	go func() {
-		for i := 0; i < worker; i++ {
-			this.worker()
-		}
-	}()
+	this.engine.run(worker)
 }
+
+// Reload recompiles the rule sources while the pipeline runs, it is what
+// SIGHUP, the admin endpoint and rules_watch_s call. Targets already being
+// scanned finish on the old rules, a failed reload keeps them in use.
+func (this *dblyzer) Reload() error {
+	return this.engine.reloadRules()
+}
+
+// RulesVersion is the hash of the rules in use, reported in every dbio.Report.
+func (this *dblyzer) RulesVersion() string {
+	return this.engine.w.RulesVersion()
+}
+
+// Wait blocks until in is closed and every target is reported.
 func (this *dblyzer) Wait() {
-	<-this.kill
-}
\ No newline at end of file
+	<-this.engine.done
+}
+
+// Status is a snapshot of the scan counters and queues.
+func (this *dblyzer) Status() Status {
+	return this.engine.status()
+}
+
+// WriteMetrics writes the scan counters in the prometheus text format.
+func (this *dblyzer) WriteMetrics(w io.Writer) {
+	writeMetrics(w, this.engine.status())
+}
+
+// Progress is a one line summary of the scan.
+func (this *dblyzer) Progress() string {
+	return progressLine(this.engine.status())
+}
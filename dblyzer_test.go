@@ -0,0 +1,97 @@
+package dblyzer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPipelineSinks(t *testing.T) {
+	srv := newTestSite(t)
+	path := filepath.Join(t.TempDir(), "output.txt")
+	file, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var console bytes.Buffer
+
+	in := make(chan Receive, 2)
+	in <- testTarget(t, srv)
+	in <- Receive{Host: "127.0.0.1", Ip: "127.0.0.1", Port: 22, Service: "ssh"}
+	close(in)
+	db := New(testRules, in, nil, file, NewConsoleSink(&console))
+	db.Run(2)
+	db.Wait()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	status := make(map[int]string)
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var rp Report
+		if err := json.Unmarshal(lines.Bytes(), &rp); err != nil {
+			t.Fatal(err)
+		}
+		status[rp.Port] = rp.Status
+	}
+	if len(status) != 2 || status[22] != StatusNotHTTP {
+		t.Errorf("file sink got %v", status)
+	}
+	if n := strings.Count(console.String(), "\n"); n != 2 {
+		t.Errorf("console sink wrote %d lines:\n%s", n, console.String())
+	}
+}
+
+func TestPipelineSources(t *testing.T) {
+	srv := newTestSite(t)
+	target := testTarget(t, srv)
+	line, err := json.Marshal(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "in.txt")
+	if err := ioutil.WriteFile(path, append(line, "\nnot json\n"...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		src  Source
+	}{
+		{"targets", Targets(target)},
+		{"file", FileSource(path)},
+		{"glob", GlobSource(filepath.Dir(path))},
+		{"func", SourceFunc(func(ctx context.Context, out chan<- Receive) error {
+			out <- target
+			return nil
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, errc := Feed(context.Background(), tt.src)
+			out := make(chan Report, 4)
+			db := New(testRules, in, out)
+			db.Run(1)
+			db.Wait()
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			var reports []Report
+			for rp := range out {
+				reports = append(reports, rp)
+			}
+			if len(reports) != 1 || reports[0].Port != target.Port || reports[0].Status != StatusOK {
+				t.Errorf("got %+v", reports)
+			}
+		})
+	}
+}
@@ -1,156 +1,387 @@
 package dblyzer
 
 import (
+	"context"
 	"crypto/md5"
+	"dblyzer/internal/config"
 	"dblyzer/internal/dbio"
 	"dblyzer/internal/httpclient"
+	"dblyzer/internal/logger"
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type engine struct {
-	in     chan dbio.Receive
-	out    chan dbio.Report
-	w      *Wappalyzer
-	header map[string]string
+	in         chan dbio.Receive
+	queue      chan dbio.Receive
+	out        chan dbio.Report
+	sink       chan dbio.Report
+	sinkDone   chan struct{}
+	w          *Wappalyzer
+	header     map[string]string
+	limiter    *httpclient.RateLimiter
+	services   serviceClassifier
+	categories categoryFilter
+	scheduler  *scheduler
+	favicons   *faviconCache
+	metrics    *metrics
+	probes     []string
+	evidence   string
+	transport  http.RoundTripper
+	//closed when the pipeline has drained
+	done chan struct{}
 }
 
-func newEngine(filePath string, in chan dbio.Receive, out chan dbio.Report) *engine {
-	e := &engine{
-		in:     in,
-		out:    out,
-		w:      newWappalyzer(filePath, 40),
+// probed after the root page, its redirect and the favicon
+var defaultProbes = []string{"/console"}
+
+// options of an engine, the pipeline takes them from config.Conf and library
+// users from the Option funcs of NewScanner
+type options struct {
+	rules      []string
+	fallback   string
+	analyzers  int
+	probes     []string
+	evidence   string
+	categories []string
+	transport  http.RoundTripper
+}
+
+func configOptions(sources []string) options {
+	return options{
+		rules:      sources,
+		fallback:   config.Conf.RegexFallback,
+		analyzers:  config.Conf.Analyzers,
+		probes:     defaultProbes,
+		evidence:   config.Conf.Evidence,
+		categories: config.Conf.Categories,
+	}
+}
+
+// buildEngine compiles the rules and sets up everything a scan needs,
+// without the channels and goroutines of the pipeline
+func buildEngine(o options) (*engine, error) {
+	w, err := newWappalyzer(o.rules, o.fallback, o.analyzers)
+	if err != nil {
+		return nil, err
+	}
+	return &engine{
+		w:      w,
 		header: nil,
+		limiter: httpclient.NewRateLimiter(
+			config.Conf.RateLimit,
+			config.Conf.HostRateLimit,
+			time.Duration(config.Conf.RateJitter)*time.Millisecond,
+		),
+		services:   newServiceClassifier(config.Conf.Services),
+		categories: newCategoryFilter(o.categories),
+		scheduler:  newScheduler(),
+		favicons:   newFaviconCache(),
+		metrics:    newMetrics(),
+		probes:     o.probes,
+		evidence:   o.evidence,
+		transport:  o.transport,
+	}, nil
+}
+
+func newEngine(sources []string, in chan dbio.Receive, out chan dbio.Report, sinks []dbio.Sink) *engine {
+	e, err := buildEngine(configOptions(sources))
+	if err != nil {
+		panic(err.Error())
+	}
+	e.in = in
+	e.out = out
+	e.queue = make(chan dbio.Receive, lookahead)
+	e.done = make(chan struct{})
+	e.schedule()
+	if len(sinks) > 0 {
+		e.sink, e.sinkDone = dbio.Pump(dbio.Suppress(dbio.MultiSink(sinks...)))
+	}
+	report := e.w.CompileReport()
+	report.Log()
+
+	if config.Conf.RulesWatch > 0 {
+		watchRules(sources, time.Duration(config.Conf.RulesWatch)*time.Second, func() {
+			e.reloadRules()
+		})
 	}
 	return e
 }
 
-func (this *engine) scan(rc dbio.Receive) dbio.Report {
+func (this *engine) reloadRules() error {
+	if err := this.w.Reload(); err != nil {
+		logger.Error("rules reload failed", "rules", this.w.RulesVersion(), "err", err)
+		return err
+	}
+	report := this.w.CompileReport()
+	report.Log()
+	logger.Info("rules reloaded", "rules", this.w.RulesVersion())
+	return nil
+}
+
+func (this *engine) scan(ctx context.Context, rc dbio.Receive) dbio.Report {
 
 	var rp dbio.Report
+	var client httpclient.Client
+	defer func() {
+		this.metrics.observe(&rp, client.Requests)
+	}()
 
 	rp.Host = rc.Host
 	rp.Ip = rc.Ip
 	rp.Port = rc.Port
 
-	if isHTTPS(rc.Service) {
-		rc.Service = "https"
-	} else if isHTTP(rc.Service) {
-		rc.Service = "http"
+	scheme := this.services.scheme(rc.Service)
+	if scheme != "http" && scheme != "https" {
+		rp.Service = rc.Service
+		rp.Status = dbio.StatusNotHTTP
+		return rp
 	}
 
+	rc.Service = scheme
 	rp.Service = rc.Service
 
-	if rc.Service != "http" && rc.Service != "https" {
-		return rp
-	}
-
-	client := httpclient.Client{
+	client = httpclient.Client{
 		Session:          false,
 		Following:        false,
 		DisableUrlEncode: false,
 		Analyze:          false,
-		Retry:            1,
+		Retry:            config.Conf.Retry,
+		RetryDelay:       time.Duration(config.Conf.RetryDelay) * time.Millisecond,
 		ReadTimeout:      0,
-		MaxBodySize:      0,
+		MaxBodySize:      config.Conf.MaxBodySize,
+		Limiter:          this.limiter,
+		Transport:        this.transport,
+		Context:          ctx,
 	}
 
 	url := getURL(rc)
 
-	r := client.Get(url, this.header)
+	r := this.probe(&client, "root", url)
 
 	if !r.Success {
+		rp.Status = dbio.StatusFailed
+		rp.Error = scanError("root", r)
+		logger.Debug("target failed", "target", url, "probe", "root", "class", rp.Error.Category, "err", rp.Error.Message)
+		if len(client.Errors) > 0 {
+			rp.Errors = client.Errors
+		}
 		return rp
 	}
 
 	rp.CName = r.CommonName
 	rp.Banner = headerToString(r.Headers, r.Proto, r.Status) + r.Text
-	rp.Domains = appendDomains(rp.Domains, extractDomains(r.Text))
 
-	resA := this.w.Analyze(r)
-	apps := resA.R
-	for _, app := range apps {
-		if rp.IsSetApp(app.AppName) {
-			continue
+	rp.ContentLength = r.ContentLength
+	rp.Truncated = r.Truncated
+	rules := this.w.ruleSet()
+	rp.Rules = rules.version
+
+	if rc.Service == "http" {
+		if twin := this.httpsTwin(rc, r); twin != "" {
+			rp.Status = dbio.StatusRedirect
+			rp.Redirect = twin
+			if len(client.Errors) > 0 {
+				rp.Errors = client.Errors
+			}
+			return rp
 		}
-		rp.Apps = append(rp.Apps, dbio.WebApp{
-			AppName: app.AppName,
-			Version: app.Version,
-			Implies: app.Implies,
-		})
 	}
 
+	rp.Domains = appendDomains(rp.Domains, extractDomains(r.Text))
+	seen := make(seenPatterns)
+	resA, err := this.w.analyze(ctx, rules, r)
+	if err != nil {
+		aborted(&rp, &client, "root", err)
+		return rp
+	}
+	rootIcon := resA.Icon
+	this.mergeApps(&rp, resA, seen)
+
 	client.Following = true
 
-	r = client.Get(url, this.header)
-	resA = this.w.Analyze(r)
-	apps = resA.R
-	for _, app := range apps {
-		if rp.IsSetApp(app.AppName) {
-			continue
-		}
-		rp.Apps = append(rp.Apps, dbio.WebApp{
-			AppName: app.AppName,
-			Version: app.Version,
-			Implies: app.Implies,
-		})
+	r = this.probe(&client, "follow", url)
+	if resA, err = this.w.analyze(ctx, rules, r); err != nil {
+		aborted(&rp, &client, "follow", err)
+		return rp
 	}
+	this.mergeApps(&rp, resA, seen)
 	rp.Domains = appendDomains(rp.Domains, extractDomains(r.Text))
 
+	// icon of the followed page, then of the root page, then /favicon.ico
 	favLink := resA.Icon
-	if favLink != "" {
-		if httpLink.MatchString(favLink) {
-			r = client.Get(favLink, nil)
-			if r.Success {
-				favSum := fmt.Sprintf("%x", md5.Sum([]byte(r.Text)))
-				rp.Favicon = favSum
-			}
-		} else {
-			if !strings.HasPrefix(favLink, "/") {
-				favLink = "/" + favLink
-			}
-			r = client.Get(url+favLink, nil)
-			if r.Success {
-				favSum := fmt.Sprintf("%x", md5.Sum([]byte(r.Text)))
-				rp.Favicon = favSum
-			}
-		}
-	} else {
-		r = client.Get(url+"/favicon.ico", nil)
-		if r.Success {
-			favSum := fmt.Sprintf("%x", md5.Sum([]byte(r.Text)))
-			rp.Favicon = favSum
+	if favLink == "" {
+		favLink = rootIcon
+	}
+	if favLink == "" {
+		favLink = url + "/favicon.ico"
+	} else if !httpLink.MatchString(favLink) {
+		if !strings.HasPrefix(favLink, "/") {
+			favLink = "/" + favLink
 		}
+		favLink = url + favLink
 	}
+	rp.Favicon = this.favicon(&client, favLink)
 
-	r = client.Get(url+"/console", this.header)
-	resA = this.w.Analyze(r)
-	apps = resA.R
-	for _, app := range apps {
-		if rp.IsSetApp(app.AppName) {
-			continue
+	for _, path := range this.probes {
+		r = this.probe(&client, path, url+path)
+		if resA, err = this.w.analyze(ctx, rules, r); err != nil {
+			aborted(&rp, &client, path, err)
+			return rp
 		}
-		rp.Apps = append(rp.Apps, dbio.WebApp{
-			AppName: app.AppName,
-			Version: app.Version,
-			Implies: app.Implies,
-		})
+		this.mergeApps(&rp, resA, seen)
 	}
 
+	sort.Slice(rp.Apps, func(i, j int) bool {
+		return rp.Apps[i].AppName < rp.Apps[j].AppName
+	})
+	rp.Status = dbio.StatusOK
+	if len(rp.Apps) == 0 {
+		rp.Status = dbio.StatusNoApps
+	}
+	if len(client.Errors) > 0 {
+		rp.Errors = client.Errors
+	}
 	return rp
 }
 
-func (this *engine) worker() {
-	go func() {
-		for {
-			j := <-this.in
+// aborted fails a scan cancelled at stage, e.g. by its context
+func aborted(rp *dbio.Report, client *httpclient.Client, stage string, err error) {
+	rp.Status = dbio.StatusFailed
+	rp.Apps = nil
+	rp.Error = &dbio.ScanError{Stage: stage, Category: httpclient.Classify(err), Message: err.Error()}
+	if len(client.Errors) > 0 {
+		rp.Errors = client.Errors
+	}
+}
+
+// favicon returns the md5 of the icon at link, icons already fetched by any
+// worker are served from the cache, by requested and final url
+func (this *engine) favicon(client *httpclient.Client, link string) string {
+	if hash, ok := this.favicons.get(link); ok {
+		return hash
+	}
+	start := time.Now()
+	r := client.GetLimit(link, nil, config.Conf.MaxFaviconSize)
+	trace("favicon", link, r, start)
+	// the hash of a cut favicon matches nothing
+	if !r.Success || r.Truncated {
+		return ""
+	}
+	if hash, ok := this.favicons.get(r.URL); ok {
+		this.favicons.put(hash, link)
+		return hash
+	}
+	hash := fmt.Sprintf("%x", md5.Sum([]byte(r.Text)))
+	this.favicons.put(hash, link, r.URL)
+	return hash
+}
+
+// probe gets url with the engine's headers and traces it at debug level
+func (this *engine) probe(client *httpclient.Client, name string, url string) httpclient.Response {
+	start := time.Now()
+	r := client.Get(url, this.header)
+	trace(name, url, r, start)
+	return r
+}
 
-			res := this.scan(j)
+func trace(probe string, url string, r httpclient.Response, start time.Time) {
+	if !logger.Enabled(logger.LevelDebug) {
+		return
+	}
+	if !r.Success {
+		logger.Debug("probe failed", "probe", probe, "url", url, "class", r.ErrorCategory, "err", r.Error, "took", time.Since(start))
+		return
+	}
+	logger.Debug("probe", "probe", probe, "url", url, "final", r.URL, "status", r.StatusCode, "bytes", r.Size, "truncated", r.Truncated, "took", time.Since(start))
+}
 
-			if res.Service == "" {
-				continue
+// seenPatterns records the app patterns already counted in a report's
+// confidence, keyed by app, source, key and pattern
+type seenPatterns map[string]bool
+
+// mergeApps adds the apps of one probe. An app found again keeps the union of
+// the evidence and the most specific version, only patterns no earlier probe
+// matched add to its confidence, and the probe url is recorded.
+func (this *engine) mergeApps(rp *dbio.Report, res Results, seen seenPatterns) {
+	for _, app := range res.R {
+		if !this.categories.allows(app.Categories) {
+			continue
+		}
+		confidence := 0
+		for _, e := range app.Evidence {
+			key := app.AppName + "\x00" + e.Source + "\x00" + e.Key + "\x00" + e.Pattern
+			if !seen[key] {
+				seen[key] = true
+				confidence += e.Confidence
 			}
-			this.out <- res
 		}
+		if confidence > 100 {
+			confidence = 100
+		}
+
+		existing := rp.GetApp(app.AppName)
+		if existing == nil {
+			rp.Apps = append(rp.Apps, dbio.WebApp{
+				AppName:    app.AppName,
+				Categories: app.Categories,
+				Implies:    app.Implies,
+				Confidence: app.Confidence,
+			})
+			existing = &rp.Apps[len(rp.Apps)-1]
+		} else if confidence > 0 {
+			existing.Confidence = combineConfidence(existing.Confidence, confidence)
+		}
+		if moreSpecific(app.Version, existing.Version) {
+			existing.Version = app.Version
+		}
+		existing.Evidence = unionEvidence(existing.Evidence, evidence(this.evidence, app.Evidence))
+		if res.URL != "" && !contains(existing.Probes, res.URL) {
+			existing.Probes = append(existing.Probes, res.URL)
+		}
+	}
+}
+
+// run starts n workers, done is closed once in is closed, they reported
+// every target and the sinks are closed
+func (this *engine) run(n int) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			this.worker()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		if this.out != nil {
+			close(this.out)
+		}
+		if this.sink != nil {
+			close(this.sink)
+			<-this.sinkDone
+		}
+		close(this.done)
 	}()
 }
+
+func (this *engine) worker() {
+	for j := range this.queue {
+		res := this.scan(context.Background(), j)
+
+		if res.Service == "" {
+			continue
+		}
+		if this.out != nil {
+			this.out <- res
+		}
+		if this.sink != nil {
+			this.sink <- res
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package dblyzer
+
+import (
+	"context"
+	"crypto/md5"
+	"dblyzer/internal/dbio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testRules = "testdata/apps.json"
+
+const testPage = `<html><head>
+<meta name="generator" content="WordPress 5.8">
+<link rel="icon" href="/first.ico">
+<link rel="shortcut icon" href="/second.ico">
+<script src="/wp-includes/js/jquery/jquery-3.5.1.min.js"></script>
+<script src="/js/jquery-1.12.4.js"></script>
+</head><body><b>backref</b></body></html>`
+
+// newTestSite serves a WordPress page behind nginx, /console and /admin give
+// two equally specific nginx versions
+func newTestSite(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		w.Header().Set("X-Powered-By", "PHP/7.4.3")
+		fmt.Fprint(w, testPage)
+	})
+	mux.HandleFunc("/console", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.18.0")
+	})
+	mux.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.19.0")
+	})
+	mux.HandleFunc("/first.ico", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "first")
+	})
+	mux.HandleFunc("/second.ico", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "second")
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testTarget(t *testing.T, srv *httptest.Server) dbio.Receive {
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return dbio.Receive{Host: u.Hostname(), Ip: u.Hostname(), Port: port, Service: "http"}
+}
+
+func testEngine(t *testing.T) *engine {
+	e, err := buildEngine(options{
+		rules:    []string{testRules},
+		fallback: "backtrack",
+		probes:   []string{"/console", "/admin"},
+		evidence: "full",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestScanDeterministic(t *testing.T) {
+	srv := newTestSite(t)
+	target := testTarget(t, srv)
+
+	var first []byte
+	for i := 0; i < 20; i++ {
+		// a new engine each time so the favicon cache does not hide the choice
+		rp := testEngine(t).scan(context.Background(), target)
+		if rp.Status != dbio.StatusOK {
+			t.Fatalf("status %s, error %+v", rp.Status, rp.Error)
+		}
+		got, err := json.Marshal(struct {
+			Apps    []dbio.WebApp
+			Favicon string
+		}{rp.Apps, rp.Favicon})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = got
+			checkScan(t, rp)
+			continue
+		}
+		if string(got) != string(first) {
+			t.Fatalf("run %d differs:\n%s\nfirst:\n%s", i, got, first)
+		}
+	}
+}
+
+func checkScan(t *testing.T, rp dbio.Report) {
+	t.Helper()
+	if !sort.SliceIsSorted(rp.Apps, func(i, j int) bool { return rp.Apps[i].AppName < rp.Apps[j].AppName }) {
+		t.Errorf("apps not sorted: %+v", rp.Apps)
+	}
+	want := map[string]string{
+		"Backref":   "",
+		"MySQL":     "",
+		"Nginx":     "1.18.0",
+		"PHP":       "7.4.3",
+		"WordPress": "5.8",
+		// the last script of the page sets the version
+		"jQuery": "1.12.4",
+	}
+	if len(rp.Apps) != len(want) {
+		t.Errorf("got %d apps, want %d: %+v", len(rp.Apps), len(want), rp.Apps)
+	}
+	for _, app := range rp.Apps {
+		version, ok := want[app.AppName]
+		if !ok {
+			t.Errorf("unexpected app %s", app.AppName)
+		} else if app.Version != version {
+			t.Errorf("%s version %q, want %q", app.AppName, app.Version, version)
+		}
+	}
+	if icon := fmt.Sprintf("%x", md5.Sum([]byte("first"))); rp.Favicon != icon {
+		t.Errorf("favicon %s, want the first icon %s", rp.Favicon, icon)
+	}
+}
+
+func TestScanAbortedMetrics(t *testing.T) {
+	srv := newTestSite(t)
+	e, err := buildEngine(options{rules: []string{testRules}, fallback: "backtrack", analyzers: 1, evidence: "full"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the root page is fetched, then its analysis waits for a slot until ctx ends
+	e.w.slots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	rp := e.scan(ctx, testTarget(t, srv))
+	if rp.Status != dbio.StatusFailed || rp.Error == nil || rp.Error.Stage != "root" {
+		t.Fatalf("status %s, error %+v, want failed at root", rp.Status, rp.Error)
+	}
+	if n := e.status().ByStatus[dbio.StatusFailed]; n != 1 {
+		t.Errorf("%d failed targets counted, want 1: %+v", n, e.status().ByStatus)
+	}
+}
@@ -4,6 +4,11 @@ go 1.16
 
 require (
 	github.com/PuerkitoBio/goquery v1.6.1
+	github.com/andybalholm/brotli v1.0.4
+	github.com/dlclark/regexp2 v1.7.0
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
+	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.14.8
 	mvdan.cc/xurls/v2 v2.2.0
 )
@@ -11,17 +11,74 @@ type config struct {
 	ReportMode  string `yaml:"report_mode,omitempty"`
 	InputFile   string `yaml:"input_file,omitempty"`
 	OutputFile  string `yaml:"output_file,omitempty"`
+
+	//json, text, table, csv, markdown or html, default json for file and text for console
+	ReportFormat string `yaml:"report_format,omitempty"`
+
+	//record reported targets in output_file.ckpt and skip them on restart
+	Checkpoint bool `yaml:"checkpoint,omitempty"`
+
+	//database of report_mode sqlite, default dblyzer.db
+	SqliteFile string `yaml:"sqlite_file,omitempty"`
+
+	//address receive_mode remote listens on for dbgrab json lines
+	InputListen string `yaml:"input_listen,omitempty"`
+
+	//apps.json files or technologies directories, later ones override apps of the same name
+	Rules []string `yaml:"rules,omitempty"`
+	//seconds between checks for changed rule files, 0 disables the watcher
+	RulesWatch int `yaml:"rules_watch_s,omitempty"`
+
+	//address of the admin http endpoint (POST /reload), empty disables it
+	AdminListen string `yaml:"admin_listen,omitempty"`
+
+	//address serving /metrics (prometheus) and /status (json), empty disables it
+	MetricsListen string `yaml:"metrics_listen,omitempty"`
+	//seconds between progress lines on stderr, 0 (default) disables them
+	Progress int `yaml:"progress_s,omitempty"`
+
+	//log level debug, info, warn or error (default info), format text or json, log_file default stderr
+	LogLevel  string `yaml:"log_level,omitempty"`
+	LogFormat string `yaml:"log_format,omitempty"`
+	LogFile   string `yaml:"log_file,omitempty"`
+
+	//max wappalyzer analyses running at once, 0 lets every worker analyze
+	Analyzers int `yaml:"analyzers,omitempty"`
+
+	//regex engine for apps.json patterns re2 rejects: backtrack, pcre (-tags pcre) or none
+	RegexFallback string `yaml:"regex_fallback,omitempty"`
+
+	//service name -> http or https, "" to skip the service
+	Services map[string]string `yaml:"services,omitempty"`
+
+	//only report apps in these categories (ids or names), empty reports all
+	Categories []string `yaml:"categories,omitempty"`
+
+	//evidence per detected app: off, summary (source, key, url) or full (+ match, pattern)
+	Evidence string `yaml:"evidence,omitempty"`
+
+	//drop not http and failed targets from the report
+	SuppressFailed bool `yaml:"suppress_failed,omitempty"`
+
+	RateLimit     float64 `yaml:"rate_limit,omitempty"`
+	HostRateLimit float64 `yaml:"host_rate_limit,omitempty"`
+	RateJitter    int     `yaml:"rate_jitter_ms,omitempty"`
+
+	Retry      int `yaml:"retry,omitempty"`
+	RetryDelay int `yaml:"retry_delay_ms,omitempty"`
+
+	//body size limits in bytes
+	MaxBodySize    int64 `yaml:"max_body_size,omitempty"`
+	MaxFaviconSize int64 `yaml:"max_favicon_size,omitempty"`
 }
 
-var Conf = config{}
+var Conf = config{Retry: 1, MaxFaviconSize: 1 << 16, RegexFallback: "backtrack"}
 
-func init() {
-	yamlFile, err := ioutil.ReadFile("config.yaml")
-	if err != nil {
-		panic("Need config.yaml")
-	}
-	err = yaml.Unmarshal(yamlFile, &Conf)
+// Load reads a yaml config file over the defaults in Conf.
+func Load(path string) error {
+	yamlFile, err := ioutil.ReadFile(path)
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
+	return yaml.Unmarshal(yamlFile, &Conf)
 }
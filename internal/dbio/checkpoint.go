@@ -0,0 +1,140 @@
+package dbio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Checkpoint is the set of targets already reported, kept on disk as 8 byte
+// hashes of host, ip and port so a killed scan can resume. It also drops
+// targets queued twice in one input.
+type Checkpoint struct {
+	mu      sync.Mutex
+	seen    map[uint64]struct{}
+	pending []byte
+	f       *os.File
+	skipped int
+}
+
+// CheckpointPath is where the checkpoint of an output file lives
+func CheckpointPath(outputFile string) string {
+	if outputFile == "" {
+		outputFile = "dblyzer"
+	}
+	return outputFile + ".ckpt"
+}
+
+// OpenCheckpoint loads the keys stored at path, an empty path keeps them in memory only
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	c := &Checkpoint{seen: make(map[uint64]struct{})}
+	if path == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// a partial last key from a kill mid write is ignored
+	for i := 0; i+8 <= len(data); i += 8 {
+		c.seen[binary.LittleEndian.Uint64(data[i:])] = struct{}{}
+	}
+	c.f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if rest := len(data) % 8; rest != 0 {
+		if err := c.f.Truncate(int64(len(data) - rest)); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func checkpointKey(host string, ip string, port int) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(host))
+	h.Write([]byte{0})
+	h.Write([]byte(ip))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(port)))
+	return h.Sum64()
+}
+
+// Len is the number of targets done or queued
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.seen)
+}
+
+// Skipped is the number of targets Filter dropped as done or queued before
+func (c *Checkpoint) Skipped() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skipped
+}
+
+// Filter forwards the targets not reported yet and not already forwarded,
+// the returned channel is closed with in
+func (c *Checkpoint) Filter(in chan Receive) chan Receive {
+	out := make(chan Receive, cap(in))
+	go func() {
+		defer close(out)
+		for r := range in {
+			key := checkpointKey(r.Host, r.Ip, r.Port)
+			c.mu.Lock()
+			_, done := c.seen[key]
+			if done {
+				c.skipped++
+			} else {
+				c.seen[key] = struct{}{}
+			}
+			c.mu.Unlock()
+			if !done {
+				out <- r
+			}
+		}
+	}()
+	return out
+}
+
+// Write marks a target done, it is stored on the next Flush. Wrap the sinks
+// it guards with Confirmed so only reports they stored are marked.
+func (c *Checkpoint) Write(rp Report) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], checkpointKey(rp.Host, rp.Ip, rp.Port))
+	c.mu.Lock()
+	c.pending = append(c.pending, b[:]...)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Checkpoint) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil || len(c.pending) == 0 {
+		c.pending = c.pending[:0]
+		return nil
+	}
+	_, err := c.f.Write(c.pending)
+	c.pending = c.pending[:0]
+	if err != nil {
+		return fmt.Errorf("checkpoint: %s", err)
+	}
+	return nil
+}
+
+func (c *Checkpoint) Close() error {
+	err := c.Flush()
+	if c.f != nil {
+		if cerr := c.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package dbio
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.ckpt")
+	a := Receive{Host: "a", Ip: "10.0.0.1", Port: 80}
+	b := Receive{Host: "b", Ip: "10.0.0.2", Port: 443}
+
+	c, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(Report{Host: a.Host, Ip: a.Ip, Port: a.Port}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Len() != 1 {
+		t.Fatalf("%d targets done, want 1", c.Len())
+	}
+	in := make(chan Receive, 4)
+	in <- a
+	in <- b
+	in <- b
+	close(in)
+	var got []Receive
+	for r := range c.Filter(in) {
+		got = append(got, r)
+	}
+	if len(got) != 1 || got[0] != b {
+		t.Errorf("forwarded %+v, want only %+v", got, b)
+	}
+	if n := c.Skipped(); n != 2 {
+		t.Errorf("skipped %d, want the done target and the duplicate", n)
+	}
+}
@@ -0,0 +1,167 @@
+package dbio
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ChangeServiceAdded   = "service_added"
+	ChangeServiceRemoved = "service_removed"
+	ChangeServiceChanged = "service_changed"
+	ChangeStatus         = "status_changed"
+	ChangeAppAdded       = "app_added"
+	ChangeAppRemoved     = "app_removed"
+	ChangeVersion        = "version_changed"
+	ChangeFavicon        = "favicon_changed"
+	ChangeCert           = "cert_changed"
+	ChangeDomainAdded    = "domain_added"
+)
+
+// Change is one difference between two scans of the same host:port
+type Change struct {
+	Key  string `json:"key"`
+	Kind string `json:"kind"`
+	App  string `json:"app,omitempty"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+}
+
+func (c Change) String() string {
+	return strings.TrimSpace(c.line())
+}
+
+func (c Change) line() string {
+	switch c.Kind {
+	case ChangeServiceAdded:
+		return fmt.Sprintf("+ %s %s", c.Key, c.New)
+	case ChangeServiceRemoved:
+		return fmt.Sprintf("- %s %s", c.Key, c.Old)
+	case ChangeAppAdded:
+		return fmt.Sprintf("+ %s app %s %s", c.Key, c.App, c.New)
+	case ChangeAppRemoved:
+		return fmt.Sprintf("- %s app %s %s", c.Key, c.App, c.Old)
+	case ChangeDomainAdded:
+		return fmt.Sprintf("+ %s domain %s", c.Key, c.New)
+	case ChangeVersion:
+		return fmt.Sprintf("~ %s app %s %s -> %s", c.Key, c.App, c.Old, c.New)
+	}
+	return fmt.Sprintf("~ %s %s %s -> %s", c.Key, c.Kind, c.Old, c.New)
+}
+
+// ReportKey identifies a report across scans
+func ReportKey(rp Report) string {
+	host := rp.Host
+	if host == "" {
+		host = rp.Ip
+	}
+	return host + ":" + strconv.Itoa(rp.Port)
+}
+
+// ReadReports reads a dblyzer jsonl output, blank lines are skipped and a
+// later report of the same host:port replaces an earlier one
+func ReadReports(path string) (map[string]Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeReports(f)
+}
+
+func DecodeReports(r io.Reader) (map[string]Report, error) {
+	reports := make(map[string]Report)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rp Report
+		if err := json.Unmarshal(scanner.Bytes(), &rp); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		reports[ReportKey(rp)] = rp
+	}
+	return reports, scanner.Err()
+}
+
+// Diff lists what changed from the prev scan to the cur one, sorted by host:port
+func Diff(prev map[string]Report, cur map[string]Report) []Change {
+	keys := make([]string, 0, len(prev)+len(cur))
+	for k := range prev {
+		keys = append(keys, k)
+	}
+	for k := range cur {
+		if _, ok := prev[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []Change
+	for _, key := range keys {
+		o, inPrev := prev[key]
+		n, inCur := cur[key]
+		switch {
+		case !inPrev:
+			changes = append(changes, Change{Key: key, Kind: ChangeServiceAdded, New: n.Service})
+			changes = append(changes, diffReport(key, Report{}, n)...)
+		case !inCur:
+			changes = append(changes, Change{Key: key, Kind: ChangeServiceRemoved, Old: o.Service})
+		default:
+			if o.Service != n.Service {
+				changes = append(changes, Change{Key: key, Kind: ChangeServiceChanged, Old: o.Service, New: n.Service})
+			}
+			if o.Status != n.Status {
+				changes = append(changes, Change{Key: key, Kind: ChangeStatus, Old: o.Status, New: n.Status})
+			}
+			changes = append(changes, diffReport(key, o, n)...)
+		}
+	}
+	return changes
+}
+
+func diffReport(key string, o Report, n Report) []Change {
+	var changes []Change
+	if o.Favicon != n.Favicon {
+		changes = append(changes, Change{Key: key, Kind: ChangeFavicon, Old: o.Favicon, New: n.Favicon})
+	}
+	if o.CName != n.CName {
+		changes = append(changes, Change{Key: key, Kind: ChangeCert, Old: o.CName, New: n.CName})
+	}
+
+	for _, app := range o.Apps {
+		if n.GetApp(app.AppName) == nil {
+			changes = append(changes, Change{Key: key, Kind: ChangeAppRemoved, App: app.AppName, Old: app.Version})
+		}
+	}
+	for _, app := range n.Apps {
+		before := o.GetApp(app.AppName)
+		if before == nil {
+			changes = append(changes, Change{Key: key, Kind: ChangeAppAdded, App: app.AppName, New: app.Version})
+		} else if before.Version != app.Version {
+			changes = append(changes, Change{Key: key, Kind: ChangeVersion, App: app.AppName, Old: before.Version, New: app.Version})
+		}
+	}
+
+	seen := make(map[string]bool, len(o.Domains))
+	for _, d := range o.Domains {
+		seen[d] = true
+	}
+	for _, d := range n.Domains {
+		if !seen[d] {
+			seen[d] = true
+			changes = append(changes, Change{Key: key, Kind: ChangeDomainAdded, New: d})
+		}
+	}
+	return changes
+}
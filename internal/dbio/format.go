@@ -0,0 +1,293 @@
+package dbio
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formatter renders reports. Header is written once before the first
+// report and Footer when the sink is closed.
+type Formatter interface {
+	Header(w io.Writer) error
+	Format(w io.Writer, rp Report) error
+	Footer(w io.Writer) error
+}
+
+var formatters = map[string]Formatter{
+	"json":     jsonFormatter{},
+	"text":     textFormatter{},
+	"table":    tableFormatter{},
+	"csv":      csvFormatter{},
+	"markdown": markdownFormatter{},
+	"html":     htmlFormatter{},
+}
+
+// OpenFormatter returns the formatter called name, or def when name is empty
+func OpenFormatter(name string, def string) (Formatter, error) {
+	if name == "" {
+		name = def
+	}
+	f, ok := formatters[name]
+	if !ok {
+		names := make([]string, 0, len(formatters))
+		for n := range formatters {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown report_format %q, have %v", name, names)
+	}
+	return f, nil
+}
+
+// appendable reports whether a file in f's format can take more reports,
+// html, csv and markdown files have a single header (and footer)
+func appendable(f Formatter) bool {
+	switch f.(type) {
+	case htmlFormatter, csvFormatter, markdownFormatter:
+		return false
+	}
+	return true
+}
+
+func target(rp Report) string {
+	return rp.Host + ":" + strconv.Itoa(rp.Port)
+}
+
+func categoryNames(cats []Category) string {
+	names := make([]string, 0, len(cats))
+	for _, c := range cats {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// one json line per report, the output dblyzer diff reads
+type jsonFormatter struct{}
+
+func (jsonFormatter) Header(w io.Writer) error { return nil }
+func (jsonFormatter) Footer(w io.Writer) error { return nil }
+
+func (jsonFormatter) Format(w io.Writer, rp Report) error {
+	b, err := json.Marshal(rp)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// one line per report
+type textFormatter struct{}
+
+func (textFormatter) Header(w io.Writer) error { return nil }
+func (textFormatter) Footer(w io.Writer) error { return nil }
+
+func (textFormatter) Format(w io.Writer, rp Report) error {
+	var err error
+	switch rp.Status {
+	case StatusNotHTTP:
+		_, err = fmt.Fprintf(w, "%s:%d %s not http\n", rp.Host, rp.Port, rp.Service)
+	case StatusRedirect:
+		_, err = fmt.Fprintf(w, "%s://%s:%d redirects to %s\n", rp.Service, rp.Host, rp.Port, rp.Redirect)
+	case StatusFailed:
+		if rp.Error != nil {
+			_, err = fmt.Fprintf(w, "%s://%s:%d failed at %s (%s): %s\n", rp.Service, rp.Host, rp.Port, rp.Error.Stage, rp.Error.Category, rp.Error.Message)
+		} else {
+			_, err = fmt.Fprintf(w, "%s://%s:%d failed\n", rp.Service, rp.Host, rp.Port)
+		}
+	default:
+		_, err = fmt.Fprintf(w, "%s://%s:%d use %s\n", rp.Service, rp.Host, rp.Port, appList(rp.Apps))
+	}
+	return err
+}
+
+// fixed width columns, one row per app, so it streams without knowing every row
+type tableFormatter struct{}
+
+const tableRow = "%-28s %-10s %-8s %-32s %s\n"
+
+func (tableFormatter) Header(w io.Writer) error {
+	_, err := fmt.Fprintf(w, tableRow, "TARGET", "SERVICE", "STATUS", "APP", "VERSION")
+	return err
+}
+
+func (tableFormatter) Footer(w io.Writer) error { return nil }
+
+func (tableFormatter) Format(w io.Writer, rp Report) error {
+	if len(rp.Apps) == 0 {
+		detail := ""
+		if rp.Error != nil {
+			detail = rp.Error.Stage + ": " + rp.Error.Message
+		} else if rp.Redirect != "" {
+			detail = "-> " + rp.Redirect
+		}
+		_, err := fmt.Fprintf(w, tableRow, target(rp), rp.Service, rp.Status, "-", detail)
+		return err
+	}
+	for i, app := range rp.Apps {
+		t, service, status := target(rp), rp.Service, rp.Status
+		if i > 0 {
+			t, service, status = "", "", ""
+		}
+		if _, err := fmt.Fprintf(w, tableRow, t, service, status, app.AppName, app.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// one row per host/app, hosts without apps get one row with empty app columns
+type csvFormatter struct{}
+
+var csvColumns = []string{"host", "ip", "port", "service", "status", "app", "version", "confidence", "categories", "favicon", "cname"}
+
+func (csvFormatter) Header(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvColumns)
+	cw.Flush()
+	return cw.Error()
+}
+
+func (csvFormatter) Footer(w io.Writer) error { return nil }
+
+func (csvFormatter) Format(w io.Writer, rp Report) error {
+	cw := csv.NewWriter(w)
+	row := func(app WebApp) {
+		confidence := ""
+		if app.Confidence > 0 {
+			confidence = strconv.Itoa(app.Confidence)
+		}
+		cw.Write([]string{rp.Host, rp.Ip, strconv.Itoa(rp.Port), rp.Service, rp.Status,
+			app.AppName, app.Version, confidence, categoryNames(app.Categories), rp.Favicon, rp.CName})
+	}
+	if len(rp.Apps) == 0 {
+		row(WebApp{})
+	}
+	for _, app := range rp.Apps {
+		row(app)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// a section per host
+type markdownFormatter struct{}
+
+func (markdownFormatter) Header(w io.Writer) error {
+	_, err := fmt.Fprint(w, "# dblyzer report\n")
+	return err
+}
+
+func (markdownFormatter) Footer(w io.Writer) error { return nil }
+
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+func (markdownFormatter) Format(w io.Writer, rp Report) error {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "\n## %s\n\n", mdEscape(target(rp)))
+	fmt.Fprintf(b, "- service: %s\n- status: %s\n", mdEscape(rp.Service), rp.Status)
+	if rp.CName != "" {
+		fmt.Fprintf(b, "- cert: %s\n", mdEscape(rp.CName))
+	}
+	if rp.Favicon != "" {
+		fmt.Fprintf(b, "- favicon: `%s`\n", rp.Favicon)
+	}
+	if rp.Redirect != "" {
+		fmt.Fprintf(b, "- redirects to: %s\n", mdEscape(rp.Redirect))
+	}
+	if rp.Error != nil {
+		fmt.Fprintf(b, "- error: %s (%s): %s\n", rp.Error.Stage, rp.Error.Category, mdEscape(rp.Error.Message))
+	}
+	if len(rp.Apps) > 0 {
+		fmt.Fprint(b, "\n| App | Version | Categories |\n|---|---|---|\n")
+		for _, app := range rp.Apps {
+			fmt.Fprintf(b, "| %s | %s | %s |\n", mdEscape(app.AppName), mdEscape(app.Version), mdEscape(categoryNames(app.Categories)))
+		}
+	}
+	if len(rp.Domains) > 0 {
+		fmt.Fprintf(b, "\nDomains: %s\n", mdEscape(strings.Join(rp.Domains, ", ")))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// a self-contained page with a card per host, no external css or scripts
+type htmlFormatter struct{}
+
+const htmlHeader = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>dblyzer report</title>
+<style>
+body{font-family:-apple-system,Segoe UI,Helvetica,Arial,sans-serif;background:#f4f5f7;margin:0;padding:24px;color:#222}
+h1{font-size:22px;margin:0 0 16px}
+.cards{display:grid;grid-template-columns:repeat(auto-fill,minmax(340px,1fr));gap:16px}
+.card{background:#fff;border-radius:6px;box-shadow:0 1px 3px rgba(0,0,0,.15);padding:14px 16px}
+.card h2{font-size:16px;margin:0 0 6px;word-break:break-all}
+.meta{color:#666;font-size:13px;margin-bottom:8px;word-break:break-all}
+.status{display:inline-block;border-radius:3px;padding:1px 6px;font-size:12px;color:#fff;background:#2e7d32}
+.status.no_apps{background:#757575}.status.redirect{background:#1565c0}.status.not_http{background:#9e9e9e}.status.failed{background:#c62828}
+table{border-collapse:collapse;width:100%;font-size:13px}
+td,th{text-align:left;padding:3px 4px;border-bottom:1px solid #eee}
+.domains{font-size:12px;color:#444;margin-top:8px;word-break:break-all}
+code{font-size:12px}
+</style>
+</head>
+<body>
+<h1>dblyzer report</h1>
+<div class="cards">
+`
+
+const htmlFooter = `</div>
+</body>
+</html>
+`
+
+var htmlCard = template.Must(template.New("card").Parse(`<div class="card">
+<h2>{{.Service}}://{{.Host}}:{{.Port}}</h2>
+<div class="meta"><span class="status {{.Status}}">{{.Status}}</span>{{if .Ip}} {{.Ip}}{{end}}{{if .CName}} &middot; cert {{.CName}}{{end}}</div>
+{{- if .Favicon}}
+<div class="meta">favicon <code>{{.Favicon}}</code></div>
+{{- end}}
+{{- if .Redirect}}
+<div class="meta">redirects to {{.Redirect}}</div>
+{{- end}}
+{{- with .Error}}
+<div class="meta">{{.Stage}} ({{.Category}}): {{.Message}}</div>
+{{- end}}
+{{- if .Apps}}
+<table><tr><th>App</th><th>Version</th><th>Categories</th></tr>
+{{- range .Apps}}
+<tr><td>{{.AppName}}</td><td>{{.Version}}</td><td>{{range $i, $c := .Categories}}{{if $i}}, {{end}}{{$c.Name}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Domains}}
+<div class="domains">{{range $i, $d := .Domains}}{{if $i}}, {{end}}{{$d}}{{end}}</div>
+{{- end}}
+</div>
+`))
+
+func (htmlFormatter) Header(w io.Writer) error {
+	_, err := io.WriteString(w, htmlHeader)
+	return err
+}
+
+func (htmlFormatter) Footer(w io.Writer) error {
+	_, err := io.WriteString(w, htmlFooter)
+	return err
+}
+
+func (htmlFormatter) Format(w io.Writer, rp Report) error {
+	return htmlCard.Execute(w, rp)
+}
@@ -0,0 +1,45 @@
+package dbio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFormatFailed(t *testing.T) {
+	tests := []struct {
+		name string
+		rp   Report
+		want string
+	}{
+		{"with error", Report{Host: "h", Port: 80, Service: "http", Status: StatusFailed,
+			Error: &ScanError{Stage: "root", Category: "refused", Message: "connection refused"}}, "failed"},
+		// e.g. a report file written by hand or an older version
+		{"without error", Report{Host: "h", Port: 80, Service: "http", Status: StatusFailed}, "failed"},
+	}
+	for _, tt := range tests {
+		for name, f := range formatters {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				var b bytes.Buffer
+				if err := f.Header(&b); err != nil {
+					t.Fatal(err)
+				}
+				if err := f.Format(&b, tt.rp); err != nil {
+					t.Fatal(err)
+				}
+				if err := f.Footer(&b); err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(b.String(), tt.want) {
+					t.Errorf("%q does not contain %q", b.String(), tt.want)
+				}
+			})
+		}
+	}
+
+	var b bytes.Buffer
+	textFormatter{}.Format(&b, tests[0].rp)
+	if want := "http://h:80 failed at root (refused): connection refused\n"; b.String() != want {
+		t.Errorf("text got %q, want %q", b.String(), want)
+	}
+}
@@ -1,10 +1,9 @@
 package dbio
 
 import (
-	"bufio"
+	"context"
 	"dblyzer/internal/config"
-	"encoding/json"
-	"os"
+	"dblyzer/internal/logger"
 )
 
 type Receive struct {
@@ -15,28 +14,30 @@ type Receive struct {
 	Banner  string `json:"banner,omitempty"`
 }
 
-func NewRecv() chan Receive {
-	outChan := make(chan Receive, 16)
-	switch config.Conf.ReceiveMode {
-	case "file":
-		go func() {
-			file, err := os.Open(config.Conf.InputFile)
-			if err != nil {
-				panic(err.Error())
-			}
-			defer file.Close()
-			scanner := bufio.NewScanner(file)
-			for scanner.Scan() {
-				r := Receive{}
-				err := json.Unmarshal(scanner.Bytes(), &r)
-				if err != nil {
-					continue
-				}
-				outChan <- r
-			}
-		}()
-	case "remote":
-		//todo impl
+// NewRecv opens the source selected by receive_mode and feeds it until ctx is done
+func NewRecv(ctx context.Context) (chan Receive, chan error) {
+	src, err := OpenSource(config.Conf.ReceiveMode)
+	if err != nil {
+		logger.Error("open source failed", "mode", config.Conf.ReceiveMode, "err", err)
+		panic(err.Error())
 	}
-	return outChan
+	return Feed(ctx, src)
+}
+
+// Feed reads src in the background and sends its targets on the returned
+// channel, which is closed when the source ends. The error channel then gets
+// what Read returned, nil when the source was exhausted.
+func Feed(ctx context.Context, src Source) (chan Receive, chan error) {
+	out := make(chan Receive, 16)
+	errc := make(chan error, 1)
+	go func() {
+		err := src.Read(ctx, out)
+		if err != nil && err != context.Canceled {
+			logger.Error("receive failed", "err", err)
+		}
+		close(out)
+		errc <- err
+		close(errc)
+	}()
+	return out, errc
 }
@@ -2,16 +2,62 @@ package dbio
 
 import (
 	"dblyzer/internal/config"
-	"encoding/json"
-	"fmt"
-	"os"
+	"dblyzer/internal/logger"
 	"strings"
 )
 
+const (
+	EvidenceURL     = "url"
+	EvidenceHeader  = "header"
+	EvidenceCookie  = "cookie"
+	EvidenceMeta    = "meta"
+	EvidenceHTML    = "html"
+	EvidenceScript  = "script"
+	EvidenceImplied = "implied"
+)
+
+// Evidence is one pattern match an app was detected from.
+// Key is the header, cookie or meta name, the script src, or the implying app.
+type Evidence struct {
+	Source     string `json:"source"`
+	Key        string `json:"key,omitempty"`
+	Match      string `json:"match,omitempty"`
+	Pattern    string `json:"pattern,omitempty"`
+	URL        string `json:"url,omitempty"`
+	Confidence int    `json:"confidence,omitempty"`
+}
+
+// Category of an app, a lower priority value means a more specific category
+type Category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority,omitempty"`
+}
+
 type WebApp struct {
-	AppName string   `json:"appname,omitempty"`
-	Version string   `json:"version,omitempty"`
-	Implies []string `json:"implies,omitempty"`
+	AppName    string     `json:"appname,omitempty"`
+	Version    string     `json:"version,omitempty"`
+	Confidence int        `json:"confidence,omitempty"`
+	Categories []Category `json:"categories,omitempty"`
+	Implies    []string   `json:"implies,omitempty"`
+	Evidence   []Evidence `json:"evidence,omitempty"`
+	//urls of the probes the app was found by
+	Probes []string `json:"probes,omitempty"`
+}
+
+const (
+	StatusOK      = "ok"
+	StatusNoApps  = "no_apps"
+	StatusNotHTTP = "not_http"
+	StatusFailed  = "failed"
+	//an http port redirecting to an https endpoint scanned on its own, see Redirect
+	StatusRedirect = "redirect"
+)
+
+type ScanError struct {
+	Stage    string `json:"stage"`
+	Category string `json:"category"`
+	Message  string `json:"message"`
 }
 
 type Report struct {
@@ -19,11 +65,24 @@ type Report struct {
 	Ip      string   `json:"ip"`
 	Port    int      `json:"port"`
 	Service string   `json:"service"`
+	Status  string   `json:"status"`
 	Banner  string   `json:"banner,omitempty"`
 	CName   string   `json:"cname,omitempty"`
 	Favicon string   `json:"favicon,omitempty"`
 	Apps    []WebApp `json:"apps,omitempty"`
 	Domains []string `json:"domains,omitempty"`
+
+	ContentLength int64 `json:"content_length,omitempty"`
+	Truncated     bool  `json:"truncated,omitempty"`
+
+	//the https endpoint this http port redirects to, its report has the apps
+	Redirect string `json:"redirect,omitempty"`
+
+	//version of the rule set the apps were detected with
+	Rules string `json:"rules,omitempty"`
+
+	Error  *ScanError     `json:"error,omitempty"`
+	Errors map[string]int `json:"errors,omitempty"`
 }
 
 func (this *Report) IsSetApp(field string) bool {
@@ -35,35 +94,52 @@ func (this *Report) IsSetApp(field string) bool {
 	return false
 }
 
-func NewRp() chan Report {
-	in := make(chan Report, 32)
-	switch config.Conf.ReportMode {
-	case "console":
-		go func() {
-			for {
-				mess := <-in
-				if !strings.Contains(mess.Service, "http") {
-					continue
-				}
-				fmt.Printf("\n%s://%s:%d use %s", mess.Service, mess.Host, mess.Port, mess.Apps)
-			}
-		}()
-	case "remote":
-	//todo impl
-	case "file":
-		go func() {
-			for {
-				mess := <-in
-				f, err := os.OpenFile(config.Conf.OutputFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
-				if err != nil {
-					continue
-				}
-				bytes, _ := json.Marshal(mess)
-				f.WriteString("\n")
-				f.Write(bytes)
-				f.Close()
-			}
-		}()
+func appList(apps []WebApp) string {
+	names := make([]string, 0, len(apps))
+	for _, a := range apps {
+		if a.Version != "" {
+			names = append(names, a.AppName+" "+a.Version)
+		} else {
+			names = append(names, a.AppName)
+		}
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
+
+func suppressed(rp Report) bool {
+	if !config.Conf.SuppressFailed {
+		return false
+	}
+	return rp.Status == StatusFailed || rp.Status == StatusNotHTTP
+}
+
+// GetApp returns the app with that name or nil
+func (this *Report) GetApp(field string) *WebApp {
+	for i := range this.Apps {
+		if field == this.Apps[i].AppName {
+			return &this.Apps[i]
+		}
+	}
+	return nil
+}
+
+// NewRp opens the sinks selected by report_mode, e.g. "file,sqlite", and
+// pumps the reports sent on the returned channel to them, see Pump. The
+// confirm sinks, like a Checkpoint, only get the reports all of them wrote
+// and flushed, suppressed reports included.
+func NewRp(confirm ...Sink) (chan Report, chan struct{}) {
+	var sinks []Sink
+	for _, mode := range strings.Split(config.Conf.ReportMode, ",") {
+		sink, err := OpenSink(strings.TrimSpace(mode))
+		if err != nil {
+			logger.Error("open sink failed", "mode", mode, "err", err)
+			panic(err.Error())
+		}
+		sinks = append(sinks, sink)
+	}
+	sink := Suppress(MultiSink(sinks...))
+	if len(confirm) > 0 {
+		sink = Confirmed(sink, MultiSink(confirm...))
 	}
-	return in
+	return Pump(sink)
 }
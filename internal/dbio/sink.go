@@ -0,0 +1,243 @@
+package dbio
+
+import (
+	"bufio"
+	"dblyzer/internal/config"
+	"dblyzer/internal/logger"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Sink receives the finished reports. Write and Flush are called from a
+// single goroutine, Flush when no more reports are waiting.
+type Sink interface {
+	Write(rp Report) error
+	Flush() error
+	Close() error
+}
+
+// the sinks selectable in report_mode
+var sinks = map[string]func() (Sink, error){
+	"console": newConsoleSink,
+	"file":    newFileSink,
+	"sqlite":  newSqliteSink,
+}
+
+// OpenSink opens the sink of a report_mode name
+func OpenSink(name string) (Sink, error) {
+	open, ok := sinks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown report_mode %q, have %v", name, SinkNames())
+	}
+	return open()
+}
+
+func SinkNames() []string {
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pump writes the reports sent on the returned channel to sink, flushing
+// whenever the channel runs empty. Closing the channel closes the sink, done
+// is closed after that.
+func Pump(sink Sink) (in chan Report, done chan struct{}) {
+	in = make(chan Report, 32)
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		for rp := range in {
+			if err := sink.Write(rp); err != nil {
+				logger.Error("report write failed", "target", target(rp), "err", err)
+			}
+			if len(in) == 0 {
+				if err := sink.Flush(); err != nil {
+					logger.Error("report flush failed", "err", err)
+				}
+			}
+		}
+		if err := sink.Close(); err != nil {
+			logger.Error("report close failed", "err", err)
+		}
+	}()
+	return in, done
+}
+
+// Suppress drops not http and failed reports before sink when suppress_failed is set
+func Suppress(sink Sink) Sink {
+	return suppressSink{sink}
+}
+
+type suppressSink struct {
+	Sink
+}
+
+func (s suppressSink) Write(rp Report) error {
+	if suppressed(rp) {
+		return nil
+	}
+	return s.Sink.Write(rp)
+}
+
+// MultiSink writes every report to all sinks
+func MultiSink(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+type multiSink []Sink
+
+func (m multiSink) each(f func(Sink) error) error {
+	var first error
+	for _, s := range m {
+		if err := f(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m multiSink) Write(rp Report) error {
+	return m.each(func(s Sink) error { return s.Write(rp) })
+}
+
+func (m multiSink) Flush() error {
+	return m.each(func(s Sink) error { return s.Flush() })
+}
+
+func (m multiSink) Close() error {
+	return m.each(func(s Sink) error { return s.Close() })
+}
+
+// Confirmed writes to confirm the reports sink wrote and flushed without
+// error, e.g. a Checkpoint, so a report lost by sink is scanned again
+func Confirmed(sink Sink, confirm Sink) Sink {
+	return &confirmedSink{sink: sink, confirm: confirm}
+}
+
+type confirmedSink struct {
+	sink    Sink
+	confirm Sink
+	pending []Report
+}
+
+func (s *confirmedSink) Write(rp Report) error {
+	if err := s.sink.Write(rp); err != nil {
+		return err
+	}
+	s.pending = append(s.pending, rp)
+	return nil
+}
+
+func (s *confirmedSink) Flush() error {
+	pending := s.pending
+	s.pending = s.pending[:0]
+	if err := s.sink.Flush(); err != nil {
+		return err
+	}
+	for _, rp := range pending {
+		if err := s.confirm.Write(rp); err != nil {
+			return err
+		}
+	}
+	return s.confirm.Flush()
+}
+
+func (s *confirmedSink) Close() error {
+	err := s.Flush()
+	if cerr := s.sink.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := s.confirm.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// formatSink writes the reports to w in a Formatter's format
+type formatSink struct {
+	f      Formatter
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewFormatSink writes to w, closer (may be nil) is closed with the sink.
+// header is false when w already holds earlier reports, e.g. an appended file.
+func NewFormatSink(w io.Writer, closer io.Closer, f Formatter, header bool) (Sink, error) {
+	s := &formatSink{f: f, w: bufio.NewWriterSize(w, 64*1024), closer: closer}
+	if header {
+		if err := f.Header(s.w); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func newConsoleSink() (Sink, error) {
+	f, err := OpenFormatter(config.Conf.ReportFormat, "text")
+	if err != nil {
+		return nil, err
+	}
+	return NewFormatSink(os.Stdout, nil, f, true)
+}
+
+// NewConsoleSink prints one line per report to w
+func NewConsoleSink(w io.Writer) Sink {
+	s, _ := NewFormatSink(w, nil, textFormatter{}, true)
+	return s
+}
+
+func newFileSink() (Sink, error) {
+	f, err := OpenFormatter(config.Conf.ReportFormat, "json")
+	if err != nil {
+		return nil, err
+	}
+	return openFileSink(config.Conf.OutputFile, f)
+}
+
+// NewFileSink appends one json line per report to path, the file is opened once
+func NewFileSink(path string) (Sink, error) {
+	return openFileSink(path, jsonFormatter{})
+}
+
+func openFileSink(path string, f Formatter) (Sink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if fi.Size() > 0 && !appendable(f) {
+		file.Close()
+		return nil, fmt.Errorf("%s is not empty and this report_format can't be appended to, move it away or use json", path)
+	}
+	return NewFormatSink(file, file, f, fi.Size() == 0)
+}
+
+func (s *formatSink) Write(rp Report) error {
+	return s.f.Format(s.w, rp)
+}
+
+func (s *formatSink) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *formatSink) Close() error {
+	err := s.f.Footer(s.w)
+	if ferr := s.w.Flush(); err == nil {
+		err = ferr
+	}
+	if s.closer != nil {
+		if cerr := s.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
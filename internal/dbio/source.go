@@ -0,0 +1,197 @@
+package dbio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"dblyzer/internal/config"
+	"dblyzer/internal/logger"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Source yields the targets to scan. Read sends them on out and returns
+// when the source is exhausted, ctx is done or it can't go on.
+type Source interface {
+	Read(ctx context.Context, out chan<- Receive) error
+}
+
+// the sources selectable in receive_mode
+var sources = map[string]func() (Source, error){
+	"file":   func() (Source, error) { return FileSource(config.Conf.InputFile), nil },
+	"glob":   func() (Source, error) { return GlobSource(config.Conf.InputFile), nil },
+	"stdin":  func() (Source, error) { return StdinSource(), nil },
+	"remote": func() (Source, error) { return RemoteSource(config.Conf.InputListen), nil },
+}
+
+// OpenSource opens the source of a receive_mode name
+func OpenSource(name string) (Source, error) {
+	open, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown receive_mode %q", name)
+	}
+	return open()
+}
+
+// SourceFunc adapts a function to Source
+type SourceFunc func(ctx context.Context, out chan<- Receive) error
+
+func (f SourceFunc) Read(ctx context.Context, out chan<- Receive) error {
+	return f(ctx, out)
+}
+
+// Targets is a Source of a fixed list of targets
+func Targets(list ...Receive) Source {
+	return SourceFunc(func(ctx context.Context, out chan<- Receive) error {
+		for _, r := range list {
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
+
+// FileSource reads a dbgrab output file, gzip compressed files are detected by content
+func FileSource(path string) Source {
+	return SourceFunc(func(ctx context.Context, out chan<- Receive) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return readLines(ctx, f, path, out)
+	})
+}
+
+// GlobSource reads every file matching pattern, or every file in a directory, in name order
+func GlobSource(pattern string) Source {
+	return SourceFunc(func(ctx context.Context, out chan<- Receive) error {
+		if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+			pattern = filepath.Join(pattern, "*")
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			if fi, err := os.Stat(file); err != nil || fi.IsDir() {
+				continue
+			}
+			if err := FileSource(file).Read(ctx, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// StdinSource reads dbgrab json lines from stdin
+func StdinSource() Source {
+	return SourceFunc(func(ctx context.Context, out chan<- Receive) error {
+		return readLines(ctx, os.Stdin, "stdin", out)
+	})
+}
+
+// RemoteSource listens on addr, every connection streams dbgrab json lines,
+// e.g. `cat output.txt | nc host 9000`. It runs until ctx is done and returns
+// once every connection is closed.
+func RemoteSource(addr string) Source {
+	return SourceFunc(func(ctx context.Context, out chan<- Receive) error {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		var mu sync.Mutex
+		conns := make(map[net.Conn]bool)
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+			mu.Lock()
+			for conn := range conns {
+				conn.Close()
+			}
+			mu.Unlock()
+		}()
+
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return err
+			}
+			mu.Lock()
+			conns[conn] = true
+			if ctx.Err() != nil {
+				conn.Close()
+			}
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(conns, conn)
+					mu.Unlock()
+					conn.Close()
+				}()
+				if err := readLines(ctx, conn, conn.RemoteAddr().String(), out); err != nil && ctx.Err() == nil {
+					logger.Error("receive failed", "source", conn.RemoteAddr().String(), "err", err)
+				}
+			}()
+		}
+	})
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// readLines sends one target per json line, lines that don't decode are skipped
+func readLines(ctx context.Context, r io.Reader, name string, out chan<- Receive) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		r := Receive{}
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			logger.Warn("bad input line", "source", name, "line", line, "err", err)
+			continue
+		}
+		select {
+		case out <- r:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
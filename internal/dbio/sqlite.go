@@ -0,0 +1,328 @@
+package dbio
+
+import (
+	"database/sql"
+	"dblyzer/internal/config"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS hosts (
+	id   INTEGER PRIMARY KEY,
+	host TEXT NOT NULL,
+	ip   TEXT NOT NULL,
+	UNIQUE (host, ip)
+);
+CREATE TABLE IF NOT EXISTS services (
+	id         INTEGER PRIMARY KEY,
+	host_id    INTEGER NOT NULL REFERENCES hosts(id),
+	port       INTEGER NOT NULL,
+	service    TEXT NOT NULL,
+	status     TEXT NOT NULL,
+	cname      TEXT NOT NULL DEFAULT '',
+	error      TEXT NOT NULL DEFAULT '',
+	rules      TEXT NOT NULL DEFAULT '',
+	scanned_at INTEGER NOT NULL,
+	UNIQUE (host_id, port)
+);
+CREATE TABLE IF NOT EXISTS apps (
+	service_id INTEGER NOT NULL REFERENCES services(id),
+	name       TEXT NOT NULL,
+	version    TEXT NOT NULL DEFAULT '',
+	confidence INTEGER NOT NULL DEFAULT 0,
+	categories TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS apps_name ON apps (name);
+CREATE TABLE IF NOT EXISTS domains (
+	service_id INTEGER NOT NULL REFERENCES services(id),
+	domain     TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS domains_domain ON domains (domain);
+CREATE TABLE IF NOT EXISTS favicons (
+	service_id INTEGER NOT NULL REFERENCES services(id),
+	hash       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS favicons_hash ON favicons (hash);
+`
+
+// OpenDB opens or creates a report database
+func OpenDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// one writer, sqlite serializes them anyway
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// sqliteSink stores reports in sqlite_file, the reports written between two
+// flushes share a transaction. A rescanned host:port replaces its old rows.
+type sqliteSink struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+func newSqliteSink() (Sink, error) {
+	return NewSqliteSink(config.Conf.SqliteFile)
+}
+
+func NewSqliteSink(path string) (Sink, error) {
+	if path == "" {
+		path = "dblyzer.db"
+	}
+	db, err := OpenDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteSink{db: db}, nil
+}
+
+func (s *sqliteSink) Write(rp Report) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+	}
+	// a report that fails halfway leaves none of its rows for the next commit
+	if _, err := s.tx.Exec(`SAVEPOINT report`); err != nil {
+		return err
+	}
+	if err := writeReport(s.tx, rp); err != nil {
+		if _, rerr := s.tx.Exec(`ROLLBACK TO report`); rerr != nil {
+			return rerr
+		}
+		s.tx.Exec(`RELEASE report`)
+		return err
+	}
+	_, err := s.tx.Exec(`RELEASE report`)
+	return err
+}
+
+func writeReport(tx *sql.Tx, rp Report) error {
+	var hostID, serviceID int64
+	_, err := tx.Exec(`INSERT INTO hosts (host, ip) VALUES (?, ?) ON CONFLICT DO NOTHING`, rp.Host, rp.Ip)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(`SELECT id FROM hosts WHERE host = ? AND ip = ?`, rp.Host, rp.Ip).Scan(&hostID)
+	if err != nil {
+		return err
+	}
+
+	scanErr := ""
+	if rp.Error != nil {
+		scanErr = rp.Error.Stage + " (" + rp.Error.Category + "): " + rp.Error.Message
+	}
+	_, err = tx.Exec(`INSERT INTO services (host_id, port, service, status, cname, error, rules, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (host_id, port) DO UPDATE SET service = excluded.service, status = excluded.status,
+		cname = excluded.cname, error = excluded.error, rules = excluded.rules, scanned_at = excluded.scanned_at`,
+		hostID, rp.Port, rp.Service, rp.Status, rp.CName, scanErr, rp.Rules, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(`SELECT id FROM services WHERE host_id = ? AND port = ?`, hostID, rp.Port).Scan(&serviceID)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"apps", "domains", "favicons"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE service_id = ?`, serviceID); err != nil {
+			return err
+		}
+	}
+	for _, app := range rp.Apps {
+		_, err := tx.Exec(`INSERT INTO apps (service_id, name, version, confidence, categories) VALUES (?, ?, ?, ?, ?)`,
+			serviceID, app.AppName, app.Version, app.Confidence, categoryNames(app.Categories))
+		if err != nil {
+			return err
+		}
+	}
+	for _, domain := range rp.Domains {
+		if _, err := tx.Exec(`INSERT INTO domains (service_id, domain) VALUES (?, ?)`, serviceID, domain); err != nil {
+			return err
+		}
+	}
+	if rp.Favicon != "" {
+		if _, err := tx.Exec(`INSERT INTO favicons (service_id, hash) VALUES (?, ?)`, serviceID, rp.Favicon); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteSink) Flush() error {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	return err
+}
+
+func (s *sqliteSink) Close() error {
+	err := s.Flush()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// AppHit is a service running an app
+type AppHit struct {
+	Target  string `json:"target"`
+	Service string `json:"service"`
+	App     string `json:"app"`
+	Version string `json:"version,omitempty"`
+}
+
+// QueryApp lists the services running app (case insensitive). With below set
+// only known versions lower than it are listed.
+func QueryApp(db *sql.DB, app string, below string) ([]AppHit, error) {
+	rows, err := db.Query(`SELECT h.host, h.ip, s.port, s.service, a.name, a.version
+		FROM apps a JOIN services s ON s.id = a.service_id JOIN hosts h ON h.id = s.host_id
+		WHERE a.name = ? COLLATE NOCASE ORDER BY h.host, h.ip, s.port`, app)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []AppHit
+	for rows.Next() {
+		var host, ip string
+		var port int
+		var hit AppHit
+		if err := rows.Scan(&host, &ip, &port, &hit.Service, &hit.App, &hit.Version); err != nil {
+			return nil, err
+		}
+		if below != "" && (hit.Version == "" || CompareVersions(hit.Version, below) >= 0) {
+			continue
+		}
+		if host == "" {
+			host = ip
+		}
+		hit.Target = host + ":" + strconv.Itoa(port)
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// FaviconGroup is a favicon hash served by more than one service
+type FaviconGroup struct {
+	Hash    string   `json:"hash"`
+	Targets []string `json:"targets"`
+}
+
+func QuerySharedFavicons(db *sql.DB) ([]FaviconGroup, error) {
+	rows, err := db.Query(`SELECT f.hash, CASE h.host WHEN '' THEN h.ip ELSE h.host END || ':' || s.port
+		FROM favicons f JOIN services s ON s.id = f.service_id JOIN hosts h ON h.id = s.host_id
+		WHERE f.hash IN (SELECT hash FROM favicons GROUP BY hash HAVING COUNT(*) > 1)
+		ORDER BY f.hash, 2`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []FaviconGroup
+	for rows.Next() {
+		var hash, target string
+		if err := rows.Scan(&hash, &target); err != nil {
+			return nil, err
+		}
+		if len(groups) == 0 || groups[len(groups)-1].Hash != hash {
+			groups = append(groups, FaviconGroup{Hash: hash})
+		}
+		g := &groups[len(groups)-1]
+		g.Targets = append(g.Targets, target)
+	}
+	return groups, rows.Err()
+}
+
+// DomainSeen is a domain and the number of services linking it
+type DomainSeen struct {
+	Domain   string `json:"domain"`
+	Services int    `json:"services"`
+}
+
+func QueryDomains(db *sql.DB) ([]DomainSeen, error) {
+	rows, err := db.Query(`SELECT domain, COUNT(DISTINCT service_id) FROM domains GROUP BY domain ORDER BY 2 DESC, 1`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []DomainSeen
+	for rows.Next() {
+		var d DomainSeen
+		if err := rows.Scan(&d.Domain, &d.Services); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// CompareVersions compares dotted versions part by part, numerically when both
+// parts are numbers: "1.9" < "1.10", "2" < "2.0.1". A suffix marks a pre-release
+// of its number: "1.0rc1" < "1.0rc2" < "1.0" < "1.0.1", "1.0-beta" < "1.0".
+func CompareVersions(a string, b string) int {
+	split := func(r rune) bool { return r == '.' || r == '-' || r == '_' }
+	pa, pb := strings.FieldsFunc(a, split), strings.FieldsFunc(b, split)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		// an extra number is a later release, an extra word a pre-release
+		if i >= len(pa) {
+			return -extraPart(pb[i])
+		}
+		if i >= len(pb) {
+			return extraPart(pa[i])
+		}
+		na, sa := versionPart(pa[i])
+		nb, sb := versionPart(pb[i])
+		switch {
+		case na != nb:
+			if na < nb {
+				return -1
+			}
+			return 1
+		case sa == sb:
+		case sa == "":
+			return 1
+		case sb == "":
+			return -1
+		default:
+			return strings.Compare(sa, sb)
+		}
+	}
+	return 0
+}
+
+// versionPart splits "12rc1" into 12 and "rc1", n is -1 when s does not start with a number
+func versionPart(s string) (n int, suffix string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return -1, s
+	}
+	return n, s[i:]
+}
+
+func extraPart(s string) int {
+	if n, _ := versionPart(s); n < 0 {
+		return -1
+	}
+	return 1
+}
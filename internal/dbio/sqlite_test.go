@@ -0,0 +1,105 @@
+package dbio
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.9", "1.10", -1},
+		{"2", "2.0.1", -1},
+		{"2.0.1", "2", 1},
+		{"1.18.0", "1.20", -1},
+		{"1.0rc1", "1.0", -1},
+		{"1.0", "1.0rc1", 1},
+		{"1.0rc1", "1.0rc2", -1},
+		{"1.0beta", "1.0rc1", -1},
+		{"1.0rc1", "1.0.1", -1},
+		{"1.0-rc1", "1.0", -1},
+		{"1.0", "1.0-beta", 1},
+		{"1.0-beta", "1.0.1", -1},
+		{"1.1rc1", "1.0", 1},
+		{"1.0_1", "1.0.1", 0},
+		{"1.x", "1.2", -1},
+		{"a", "b", -1},
+		{"", "1", -1},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestQueryApp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dblyzer.db")
+	sink, err := NewSqliteSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports := []Report{
+		{Host: "a.example", Ip: "10.0.0.1", Port: 80, Service: "http", Status: StatusOK,
+			Apps: []WebApp{{AppName: "Nginx", Version: "1.18.0"}, {AppName: "PHP", Version: "7.4.3"}}},
+		{Ip: "10.0.0.2", Port: 443, Service: "https", Status: StatusOK,
+			Apps: []WebApp{{AppName: "Nginx", Version: "1.21rc1"}}},
+		{Host: "c.example", Ip: "10.0.0.3", Port: 8080, Service: "http", Status: StatusOK,
+			Apps: []WebApp{{AppName: "nginx"}}},
+		{Host: "d.example", Ip: "10.0.0.4", Port: 80, Service: "http", Status: StatusOK,
+			Apps: []WebApp{{AppName: "Nginx", Version: "1.21"}}},
+	}
+	for _, rp := range reports {
+		if err := sink.Write(rp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		app   string
+		below string
+		want  []AppHit
+	}{
+		{"all", "NGINX", "", []AppHit{
+			{Target: "10.0.0.2:443", Service: "https", App: "Nginx", Version: "1.21rc1"},
+			{Target: "a.example:80", Service: "http", App: "Nginx", Version: "1.18.0"},
+			{Target: "c.example:8080", Service: "http", App: "nginx"},
+			{Target: "d.example:80", Service: "http", App: "Nginx", Version: "1.21"},
+		}},
+		{"below", "nginx", "1.21", []AppHit{
+			{Target: "10.0.0.2:443", Service: "https", App: "Nginx", Version: "1.21rc1"},
+			{Target: "a.example:80", Service: "http", App: "Nginx", Version: "1.18.0"},
+		}},
+		{"below lowest", "nginx", "1.18", nil},
+		{"other app", "php", "8", []AppHit{
+			{Target: "a.example:80", Service: "http", App: "PHP", Version: "7.4.3"},
+		}},
+		{"unknown app", "apache", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := QueryApp(db, tt.app, tt.below)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
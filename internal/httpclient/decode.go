@@ -0,0 +1,97 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/net/html/charset"
+)
+
+const acceptEncoding = "gzip, deflate, br"
+
+// decodeBody unwraps the content codings listed in Content-Encoding,
+// they are applied in order so they are removed in reverse.
+func decodeBody(body io.Reader, contentEncoding string) (io.Reader, error) {
+	if contentEncoding == "" {
+		return body, nil
+	}
+	codings := strings.Split(contentEncoding, ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(strings.TrimSpace(codings[i])) {
+		case "gzip", "x-gzip":
+			body, err = gzip.NewReader(body)
+		case "deflate":
+			body, err = deflateReader(body)
+		case "br":
+			body = brotli.NewReader(body)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// "deflate" should be zlib wrapped but some servers send raw deflate
+func deflateReader(body io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(body)
+	head, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+func isText(contentType string, body []byte) bool {
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/xhtml+xml", "application/xml", "application/json",
+		"application/javascript", "application/x-javascript", "application/rss+xml", "application/atom+xml":
+		return true
+	}
+	return false
+}
+
+// toUTF8 converts a text body to utf-8 using the BOM, the Content-Type
+// charset or the html meta charset. Binary bodies are returned untouched.
+// A guess is only made from the first 1024 bytes, so a valid utf-8 body
+// without a declared charset stays utf-8.
+func toUTF8(body []byte, contentType string) ([]byte, string) {
+	if len(body) == 0 || !isText(contentType, body) {
+		return body, ""
+	}
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && utf8.Valid(body) {
+		name = "utf-8"
+	}
+	if name == "utf-8" {
+		return bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), name
+	}
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, ""
+	}
+	return decoded, name
+}
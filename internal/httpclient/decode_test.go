@@ -0,0 +1,105 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func compress(t *testing.T, w io.WriteCloser, buf *bytes.Buffer, text string) []byte {
+	t.Helper()
+	if _, err := io.WriteString(w, text); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	const text = "<html><body>hello hello hello</body></html>"
+
+	var gz, zl, fl, br, twice bytes.Buffer
+	flw, err := flate.NewWriter(&fl, flate.DefaultCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gzip applied first, then br
+	inner := compress(t, gzip.NewWriter(&twice), &twice, text)
+	var outer bytes.Buffer
+	compress(t, brotli.NewWriter(&outer), &outer, string(inner))
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"identity", "", []byte(text)},
+		{"gzip", "gzip", compress(t, gzip.NewWriter(&gz), &gz, text)},
+		{"x-gzip", "X-Gzip", gz.Bytes()},
+		{"deflate zlib", "deflate", compress(t, zlib.NewWriter(&zl), &zl, text)},
+		{"deflate raw", "deflate", compress(t, flw, &fl, text)},
+		{"br", "br", compress(t, brotli.NewWriter(&br), &br, text)},
+		{"gzip then br", "gzip, br", outer.Bytes()},
+		{"unknown", "identity", []byte(text)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := decodeBody(bytes.NewReader(tt.body), tt.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != text {
+				t.Errorf("got %q, want %q", got, text)
+			}
+		})
+	}
+
+	if _, err := decodeBody(strings.NewReader("not gzip"), "gzip"); err == nil {
+		t.Error("no error for a bad gzip body")
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	padding := strings.Repeat("a", 2048)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		charset     string
+	}{
+		{"utf-8", "text/html; charset=utf-8", "café 日本", "café 日本", "utf-8"},
+		{"bom", "text/html", "\xef\xbb\xbf<p>café</p>", "<p>café</p>", "utf-8"},
+		{"content-type charset", "text/html; charset=iso-8859-1", "caf\xe9", "café", "windows-1252"},
+		{"meta charset", "text/html", `<html><head><meta charset="iso-8859-1"></head><body>caf` + "\xe9</body></html>",
+			`<html><head><meta charset="iso-8859-1"></head><body>café</body></html>`, "windows-1252"},
+		{"meta http-equiv", "text/html", `<meta http-equiv="Content-Type" content="text/html; charset=shift_jis">` + "\x93\xfa\x96\x7b",
+			`<meta http-equiv="Content-Type" content="text/html; charset=shift_jis">日本`, "shift_jis"},
+		{"utf-8 after 1024 bytes", "text/html", "<html><body>" + padding + "café 日本</body></html>",
+			"<html><body>" + padding + "café 日本</body></html>", "utf-8"},
+		{"latin-1 guess", "text/html", "<p>caf\xe9</p>", "<p>café</p>", "windows-1252"},
+		{"binary", "image/png", "\x89PNG\r\n\x1a\n\xe9", "\x89PNG\r\n\x1a\n\xe9", ""},
+		{"empty", "text/html", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, name := toUTF8([]byte(tt.body), tt.contentType)
+			if string(got) != tt.want || name != tt.charset {
+				t.Errorf("got %q, %q, want %q, %q", got, name, tt.want, tt.charset)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// error categories
+const (
+	ErrDNS     = "dns"
+	ErrRefused = "refused"
+	ErrTimeout = "timeout"
+	ErrTLS     = "tls"
+	ErrReset   = "reset"
+	ErrOther   = "other"
+)
+
+const defaultRetryDelay = 500 * time.Millisecond
+const maxRetryDelay = 10 * time.Second
+
+// Classify maps a transport error to one of the error categories.
+func Classify(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsTimeout {
+			return ErrTimeout
+		}
+		return ErrDNS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrRefused
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrReset
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+
+	var recordErr tls.RecordHeaderError
+	var unknownAuth x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &unknownAuth) ||
+		errors.As(err, &hostErr) || errors.As(err, &certErr) ||
+		strings.Contains(err.Error(), "tls: ") {
+		return ErrTLS
+	}
+	if strings.Contains(err.Error(), "connection reset") {
+		return ErrReset
+	}
+	return ErrOther
+}
+
+// only transient failures are worth another attempt,
+// nxdomain, refused and tls errors will fail the same way again
+func retryable(category string, err error) bool {
+	switch category {
+	case ErrTimeout, ErrReset:
+		return true
+	case ErrDNS:
+		var dnsErr *net.DNSError
+		return errors.As(err, &dnsErr) && dnsErr.IsTemporary
+	}
+	return false
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// exponential backoff with jitter in [d/2, d)
+func retryDelay(base time.Duration, retried int) time.Duration {
+	if base <= 0 {
+		base = defaultRetryDelay
+	}
+	d := base << uint(retried)
+	if d > maxRetryDelay || d <= 0 {
+		d = maxRetryDelay
+	}
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
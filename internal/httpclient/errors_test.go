@@ -0,0 +1,145 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// wrapped like the errors of http.Client.Do
+func urlError(err error) error {
+	return &url.Error{Op: "Get", URL: "http://example.com/", Err: err}
+}
+
+func opError(err error) error {
+	return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		category  string
+		retryable bool
+	}{
+		{"nil", nil, "", false},
+		{"nxdomain", urlError(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), ErrDNS, false},
+		{"dns temporary", urlError(&net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}), ErrDNS, true},
+		{"dns timeout", urlError(&net.DNSError{Err: "timeout", Name: "example.com", IsTimeout: true}), ErrTimeout, true},
+		{"refused", urlError(opError(syscall.ECONNREFUSED)), ErrRefused, false},
+		{"reset", urlError(opError(syscall.ECONNRESET)), ErrReset, true},
+		{"broken pipe", urlError(opError(syscall.EPIPE)), ErrReset, true},
+		{"eof", urlError(io.EOF), ErrReset, true},
+		{"unexpected eof", urlError(io.ErrUnexpectedEOF), ErrReset, true},
+		{"reset text", errors.New("read tcp: connection reset by peer"), ErrReset, true},
+		{"deadline", urlError(context.DeadlineExceeded), ErrTimeout, true},
+		{"net timeout", urlError(&net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}), ErrTimeout, true},
+		{"tls record", urlError(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), ErrTLS, false},
+		{"unknown authority", urlError(x509.UnknownAuthorityError{}), ErrTLS, false},
+		{"hostname", urlError(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "example.com"}), ErrTLS, false},
+		{"cert invalid", urlError(x509.CertificateInvalidError{Reason: x509.Expired}), ErrTLS, false},
+		{"tls text", fmt.Errorf("remote error: tls: handshake failure"), ErrTLS, false},
+		{"canceled", urlError(context.Canceled), ErrOther, false},
+		{"other", errors.New("something else"), ErrOther, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category := Classify(tt.err)
+			if category != tt.category {
+				t.Errorf("Classify(%v) = %q, want %q", tt.err, category, tt.category)
+			}
+			if got := retryable(category, tt.err); got != tt.retryable {
+				t.Errorf("retryable(%q, %v) = %v, want %v", category, tt.err, got, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	tests := []struct {
+		method string
+		want   bool
+	}{
+		{"", true},
+		{http.MethodGet, true},
+		{http.MethodHead, true},
+		{http.MethodOptions, true},
+		{http.MethodTrace, true},
+		{http.MethodPut, true},
+		{http.MethodDelete, true},
+		{http.MethodPost, false},
+		{http.MethodPatch, false},
+		{http.MethodConnect, false},
+		{"PROPFIND", false},
+	}
+	for _, tt := range tests {
+		if got := idempotent(tt.method); got != tt.want {
+			t.Errorf("idempotent(%q) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+}
+
+// failing answers every request with err
+type failing struct {
+	err   error
+	calls int
+}
+
+func (f *failing) RoundTrip(*http.Request) (*http.Response, error) {
+	f.calls += 1
+	return nil, f.err
+}
+
+func TestRoundTripRetries(t *testing.T) {
+	reset := urlError(opError(syscall.ECONNRESET))
+	refused := urlError(opError(syscall.ECONNREFUSED))
+	tests := []struct {
+		name     string
+		method   string
+		err      error
+		retryAll bool
+		calls    int
+	}{
+		{"get reset", http.MethodGet, reset, false, 3},
+		{"get refused", http.MethodGet, refused, false, 1},
+		{"post reset", http.MethodPost, reset, false, 1},
+		{"patch reset", http.MethodPatch, reset, false, 1},
+		{"post reset retry all", http.MethodPost, reset, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &failing{err: tt.err}
+			m := &middleware{base: f, maxRetry: 2, retryDelay: time.Millisecond, retryAll: tt.retryAll, errors: make(map[string]int)}
+			req, err := http.NewRequest(tt.method, "http://example.com/", strings.NewReader("body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.RoundTrip(req); err == nil {
+				t.Fatal("no error")
+			}
+			if f.calls != tt.calls {
+				t.Errorf("%d attempts, want %d", f.calls, tt.calls)
+			}
+			if n := m.errors[Classify(tt.err)]; n != tt.calls {
+				t.Errorf("%d errors counted, want %d", n, tt.calls)
+			}
+		})
+	}
+}
@@ -2,43 +2,64 @@ package httpclient
 
 import (
 	"bytes"
+	"context"
+	"dblyzer/internal/logger"
 	"net/http"
 )
 
-//TODO
-//Add OPTIONS, PUT,...
+// TODO
+// Add OPTIONS, PUT,...
 func (c *Client) Get(url string, header map[string]string) (r Response) {
+	return c.GetLimit(url, header, 0)
+}
+
+// GetLimit is Get with its own body size limit, 0 means Client.MaxBodySize.
+func (c *Client) GetLimit(url string, header map[string]string, maxBodySize int64) (r Response) {
 	c.preReq()
+	if maxBodySize <= 0 {
+		maxBodySize = c.MaxBodySize
+	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(c.context(), "GET", url, nil)
 	if err != nil {
-		return Response{Success: false}
+		logger.Debug("bad request", "url", url, "err", err)
+		return Response{Success: false, Error: err.Error(), ErrorCategory: ErrOther}
 	}
 	if c.DisableUrlEncode {
 		req.URL.Opaque = url[len(req.URL.Scheme)+len(`://`)+len(req.URL.Host):]
 	}
 	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
 	for key, value := range header {
 		req.Header.Set(key, value)
 	}
 
-	return c.req(req)
+	return c.req(req, maxBodySize)
 }
 
 func (c *Client) Post(url string, header map[string]string, data []byte) (r Response) {
 	c.preReq()
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(c.context(), "POST", url, bytes.NewBuffer(data))
 	if err != nil {
-		return Response{Success: false}
+		logger.Debug("bad request", "url", url, "err", err)
+		return Response{Success: false, Error: err.Error(), ErrorCategory: ErrOther}
 	}
 	if c.DisableUrlEncode {
 		req.URL.Opaque = url[len(req.URL.Scheme)+len(`://`)+len(req.URL.Host):]
 	}
 	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
 	for key, value := range header {
 		req.Header.Set(key, value)
 	}
 
-	return c.req(req)
+	return c.req(req, c.MaxBodySize)
+}
+
+func (c *Client) context() context.Context {
+	if c.Context == nil {
+		return context.Background()
+	}
+	return c.Context
 }
@@ -2,8 +2,6 @@ package httpclient
 
 import (
 	"crypto/tls"
-	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"time"
@@ -19,59 +17,81 @@ type redirects struct {
 }
 
 type middleware struct {
-	transport   http.Transport
+	transport   *http.Transport
+	base        http.RoundTripper
 	redirects   *redirects
 	analyzer    bool
 	maxRetry    int
+	retryDelay  time.Duration
+	retryAll    bool
 	readTimeout time.Duration
 	maxBodySize int64
+	limiter     *RateLimiter
+	errors      map[string]int
+	requests    *int
 }
 
-func (m middleware) appendRedirect(url string, statuscode int, size int) {
+func (m *middleware) appendRedirect(url string, statuscode int, size int) {
 	m.redirects.Count += 1
 	m.redirects.Urls = append(m.redirects.Urls, url)
 	m.redirects.StatusCodes = append(m.redirects.StatusCodes, statuscode)
 	m.redirects.Sizes = append(m.redirects.Sizes, size)
 }
 
-func (m middleware) analyze(r *Response) {
+func (m *middleware) analyze(r *Response) {
 
 	//TODO detect some security bugs
 
 	return
 }
 
-func (m middleware) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+func (m *middleware) RoundTrip(req *http.Request) (resp *http.Response, err error) {
 
-	transport := m.transport
-	//proxyUrl, _ := url.Parse("http://127.0.0.1:8080")
-	//transport.Proxy = http.ProxyURL(proxyUrl)
-	transport.MaxIdleConns = 2000
-	transport.MaxIdleConnsPerHost = 1000
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true, Renegotiation: tls.RenegotiateOnceAsClient}
-	transport.DisableKeepAlives = true
-	transport.DialContext = (&net.Dialer{
-		Timeout:   5 * time.Second,
-		KeepAlive: 1 * time.Second,
-	}).DialContext
-
-	transport.TLSHandshakeTimeout = 10 * time.Second
-	transport.IdleConnTimeout = 15 * time.Second
-	transport.ExpectContinueTimeout = 5 * time.Second
-	transport.ResponseHeaderTimeout = 10 * time.Second
-	transport.DisableCompression = true
+	var rt http.RoundTripper = m.base
+	if rt == nil {
+		rt = m.scanTransport()
+	}
 	var retried = 0
 	for {
-		resp, err = transport.RoundTrip(req)
-		if err != nil {
-			if retried == m.maxRetry {
+		if m.limiter != nil {
+			if err = m.limiter.Wait(req.Context(), req.URL.Hostname()); err != nil {
 				return
 			}
-			retried += 1
-			continue
-		} else {
+		}
+		if m.requests != nil {
+			*m.requests += 1
+		}
+		resp, err = rt.RoundTrip(req)
+		if err == nil {
 			break
 		}
+
+		category := Classify(err)
+		if m.errors != nil {
+			m.errors[category] += 1
+		}
+		if retried >= m.maxRetry || !retryable(category, err) {
+			return
+		}
+		if !idempotent(req.Method) && !m.retryAll {
+			return
+		}
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return
+			}
+			if req.Body, err = req.GetBody(); err != nil {
+				return
+			}
+		}
+		if err = sleep(req.Context(), retryDelay(m.retryDelay, retried)); err != nil {
+			return
+		}
+		retried += 1
+	}
+
+	if m.limiter != nil {
+		m.limiter.Observe(req.URL.Hostname(), resp)
 	}
 
 	//prevent redirect to other domain...
@@ -83,23 +103,38 @@ func (m middleware) RoundTrip(req *http.Request) (resp *http.Response, err error
 	}
 
 	if resp.StatusCode > 299 && resp.StatusCode < 400 {
-		var body []byte
-		readDone := make(chan int)
-		go func() {
-			body, _ = ioutil.ReadAll(io.LimitReader(resp.Body, m.maxBodySize))
-			readDone <- 1
-		}()
-
-		select {
-		case <-time.After(m.readTimeout):
-			//deadline
-		case <-readDone:
-		}
+		deadline := time.AfterFunc(m.readTimeout, func() { resp.Body.Close() })
+		body, _, _ := readBody(resp.Body, m.maxBodySize)
+		deadline.Stop()
 		resp.Body.Close()
 		size := len(body)
 		m.appendRedirect(req.URL.String(), resp.StatusCode, size)
 		resp.Body.Close()
+		//already consumed, so the caller does not read a closed body
+		resp.Body = http.NoBody
 	}
 
 	return
 }
+
+// scanTransport is the transport used when the client has none of its own
+func (m *middleware) scanTransport() *http.Transport {
+	transport := m.transport.Clone()
+	//proxyUrl, _ := url.Parse("http://127.0.0.1:8080")
+	//transport.Proxy = http.ProxyURL(proxyUrl)
+	transport.MaxIdleConns = 2000
+	transport.MaxIdleConnsPerHost = 1000
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true, Renegotiation: tls.RenegotiateOnceAsClient}
+	transport.DisableKeepAlives = true
+	transport.DialContext = (&net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 1 * time.Second,
+	}).DialContext
+
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.IdleConnTimeout = 15 * time.Second
+	transport.ExpectContinueTimeout = 5 * time.Second
+	transport.ResponseHeaderTimeout = 10 * time.Second
+	transport.DisableCompression = true
+	return transport
+}
@@ -0,0 +1,191 @@
+package httpclient
+
+import (
+	"container/list"
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff     = 1 * time.Second
+	maxBackoff     = 60 * time.Second
+	maxRetryAfter  = 5 * time.Minute
+	maxHostBuckets = 10000
+)
+
+// token bucket, rate <= 0 means unlimited
+type bucket struct {
+	m       sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	until   time.Time
+	backoff time.Duration
+}
+
+func newBucket(rate float64) *bucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take a token and return how long the caller has to wait before using it
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var wait time.Duration
+	if b.until.After(now) {
+		wait = b.until.Sub(now)
+	}
+	if b.rate <= 0 {
+		b.last = now
+		return wait
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= 1
+	if b.tokens < 0 {
+		if d := time.Duration(-b.tokens / b.rate * float64(time.Second)); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+func (b *bucket) pause(d time.Duration) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if d <= 0 {
+		if b.backoff == 0 {
+			b.backoff = minBackoff
+		} else {
+			b.backoff *= 2
+		}
+		if b.backoff > maxBackoff {
+			b.backoff = maxBackoff
+		}
+		d = b.backoff
+	}
+	if until := time.Now().Add(d); until.After(b.until) {
+		b.until = until
+	}
+}
+
+func (b *bucket) reset() {
+	b.m.Lock()
+	b.backoff = 0
+	b.m.Unlock()
+}
+
+// RateLimiter throttles requests globally and per target host/ip.
+// It is safe for concurrent use and meant to be shared by all clients of a scan.
+type RateLimiter struct {
+	global   *bucket
+	hostRate float64
+	jitter   time.Duration
+
+	m     sync.Mutex
+	hosts map[string]*list.Element
+	lru   *list.List
+}
+
+type hostBucket struct {
+	name string
+	b    *bucket
+}
+
+// globalRate and hostRate are requests per second, 0 disables the limit.
+// jitter adds a random delay in [0, jitter) before each request.
+func NewRateLimiter(globalRate float64, hostRate float64, jitter time.Duration) *RateLimiter {
+	return &RateLimiter{
+		global:   newBucket(globalRate),
+		hostRate: hostRate,
+		jitter:   jitter,
+		hosts:    make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// the bucket of host, the least recently used one is dropped past maxHostBuckets
+func (l *RateLimiter) host(name string) *bucket {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if e, ok := l.hosts[name]; ok {
+		l.lru.MoveToFront(e)
+		return e.Value.(*hostBucket).b
+	}
+	if l.lru.Len() >= maxHostBuckets {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.hosts, oldest.Value.(*hostBucket).name)
+	}
+	b := newBucket(l.hostRate)
+	l.hosts[name] = l.lru.PushFront(&hostBucket{name: name, b: b})
+	return b
+}
+
+// Wait blocks until a request to host is allowed or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context, host string) error {
+	now := time.Now()
+	wait := l.host(host).reserve(now)
+	if d := l.global.reserve(now); d > wait {
+		wait = d
+	}
+	if l.jitter > 0 {
+		wait += time.Duration(rand.Int63n(int64(l.jitter)))
+	}
+	if wait <= 0 {
+		return nil
+	}
+	return sleep(ctx, wait)
+}
+
+// Backoff pauses all requests to host for d.
+// If d <= 0, an exponential backoff per host is used instead.
+func (l *RateLimiter) Backoff(host string, d time.Duration) {
+	l.host(host).pause(d)
+}
+
+// Observe backs off the host of resp on 429/503 or Retry-After, and resets the backoff otherwise.
+func (l *RateLimiter) Observe(host string, resp *http.Response) {
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		l.Backoff(host, retryAfter(resp.Header.Get("Retry-After")))
+		return
+	}
+	if d := retryAfter(resp.Header.Get("Retry-After")); d > 0 {
+		l.Backoff(host, d)
+		return
+	}
+	l.host(host).reset()
+}
+
+// Retry-After is either delay-seconds or an http-date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var d time.Duration
+	if sec, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(sec) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d
+}
@@ -0,0 +1,214 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBucketReserve(t *testing.T) {
+	b := newBucket(2)
+	now := b.last
+
+	// the burst is free, then a token every 1/rate
+	for i := 0; i < 2; i++ {
+		if d := b.reserve(now); d != 0 {
+			t.Fatalf("reserve %d within the burst waits %v", i, d)
+		}
+	}
+	if d := b.reserve(now); d != 500*time.Millisecond {
+		t.Errorf("first reserve past the burst waits %v, want 500ms", d)
+	}
+	if d := b.reserve(now); d != time.Second {
+		t.Errorf("second reserve past the burst waits %v, want 1s", d)
+	}
+
+	// refilled, but never above the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if d := b.reserve(now); d != 0 {
+			t.Fatalf("reserve %d after the refill waits %v", i, d)
+		}
+	}
+	if d := b.reserve(now); d == 0 {
+		t.Error("the refill went past the burst")
+	}
+}
+
+func TestBucketSlowRate(t *testing.T) {
+	b := newBucket(0.5)
+	now := b.last
+	if d := b.reserve(now); d != 0 {
+		t.Fatalf("first reserve waits %v", d)
+	}
+	if d := b.reserve(now); d != 2*time.Second {
+		t.Errorf("second reserve waits %v, want 2s", d)
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	b := newBucket(0)
+	now := b.last
+	for i := 0; i < 100; i++ {
+		if d := b.reserve(now); d != 0 {
+			t.Fatalf("unlimited bucket waits %v", d)
+		}
+	}
+	b.pause(time.Minute)
+	if d := b.reserve(time.Now()); d <= 0 || d > time.Minute {
+		t.Errorf("paused unlimited bucket waits %v, want up to 1m", d)
+	}
+}
+
+func TestBucketBackoff(t *testing.T) {
+	b := newBucket(0)
+	want := []time.Duration{minBackoff, 2 * minBackoff, 4 * minBackoff}
+	for _, w := range want {
+		b.pause(0)
+		if b.backoff != w {
+			t.Errorf("backoff %v, want %v", b.backoff, w)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		b.pause(0)
+	}
+	if b.backoff != maxBackoff {
+		t.Errorf("backoff %v, want it capped at %v", b.backoff, maxBackoff)
+	}
+	b.reset()
+	b.pause(0)
+	if b.backoff != minBackoff {
+		t.Errorf("backoff %v after reset, want %v", b.backoff, minBackoff)
+	}
+
+	// a shorter pause does not cut a longer one
+	until := b.until
+	b.pause(time.Millisecond)
+	if !b.until.Equal(until) {
+		t.Errorf("pause moved until from %v to %v", until, b.until)
+	}
+}
+
+func TestHostBucketsLRU(t *testing.T) {
+	l := NewRateLimiter(0, 1, 0)
+	first := l.host("first")
+	for i := 0; i < maxHostBuckets-1; i++ {
+		l.host(fmt.Sprintf("host%d", i))
+	}
+	if l.lru.Len() != maxHostBuckets {
+		t.Fatalf("%d buckets, want %d", l.lru.Len(), maxHostBuckets)
+	}
+
+	// using first makes host0 the least recently used one
+	if l.host("first") != first {
+		t.Fatal("first got a new bucket")
+	}
+	l.host("new")
+	if l.lru.Len() != maxHostBuckets || len(l.hosts) != maxHostBuckets {
+		t.Fatalf("%d buckets, %d map entries, want %d", l.lru.Len(), len(l.hosts), maxHostBuckets)
+	}
+	if _, ok := l.hosts["host0"]; ok {
+		t.Error("host0 was not evicted")
+	}
+	if l.host("first") != first {
+		t.Error("first was evicted")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{"empty", "", 0, 0},
+		{"seconds", "120", 120 * time.Second, 120 * time.Second},
+		{"zero", "0", 0, 0},
+		{"capped", "86400", maxRetryAfter, maxRetryAfter},
+		{"date", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), 55 * time.Second, time.Minute},
+		{"past date", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), -2 * time.Minute, 0},
+		{"garbage", "soon", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := retryAfter(tt.value); d < tt.min || d > tt.max {
+				t.Errorf("retryAfter(%q) = %v, want [%v, %v]", tt.value, d, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestObserve(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		backoff    time.Duration
+		paused     bool
+	}{
+		{"ok", 200, "", 0, false},
+		{"429", 429, "", minBackoff, true},
+		{"503", 503, "", minBackoff, true},
+		{"429 retry-after", 429, "30", 0, true},
+		{"200 retry-after", 200, "30", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(0, 0, 0)
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			l.Observe("h", resp)
+			b := l.host("h")
+			if b.backoff != tt.backoff {
+				t.Errorf("backoff %v, want %v", b.backoff, tt.backoff)
+			}
+			if paused := b.until.After(time.Now()); paused != tt.paused {
+				t.Errorf("paused %v, want %v", paused, tt.paused)
+			}
+		})
+	}
+
+	// a good response resets the backoff
+	l := NewRateLimiter(0, 0, 0)
+	l.Observe("h", &http.Response{StatusCode: 429, Header: http.Header{}})
+	l.Observe("h", &http.Response{StatusCode: 429, Header: http.Header{}})
+	if b := l.host("h"); b.backoff != 2*minBackoff {
+		t.Errorf("backoff %v after two 429, want %v", b.backoff, 2*minBackoff)
+	}
+	l.Observe("h", &http.Response{StatusCode: 200, Header: http.Header{}})
+	if b := l.host("h"); b.backoff != 0 {
+		t.Errorf("backoff %v after a 200, want it reset", b.backoff)
+	}
+}
+
+func TestWaitShortRate(t *testing.T) {
+	l := NewRateLimiter(0, 50, 0)
+	ctx := context.Background()
+	start := time.Now()
+	// the burst of 50, then 5 more at 20ms each
+	for i := 0; i < 55; i++ {
+		if err := l.Wait(ctx, "h"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("55 requests at 50/s took %v, want about 100ms", d)
+	}
+	// other hosts have their own bucket
+	start = time.Now()
+	if err := l.Wait(ctx, "other"); err != nil || time.Since(start) > 50*time.Millisecond {
+		t.Errorf("other host waited %v, err %v", time.Since(start), err)
+	}
+
+	l.Backoff("h", time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, "h"); err != context.DeadlineExceeded {
+		t.Errorf("err %v while backing off, want context.DeadlineExceeded", err)
+	}
+}
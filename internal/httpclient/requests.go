@@ -1,6 +1,8 @@
 package httpclient
 
 import (
+	"context"
+	"dblyzer/internal/logger"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -21,8 +23,17 @@ type Response struct {
 	Headers    http.Header
 	Cookies    []*http.Cookie
 	Success    bool
+	URL        string
 	Path       string
 	CommonName string
+	Charset    string
+
+	//ContentLength is the declared length, -1 if unknown
+	ContentLength int64
+	Truncated     bool
+
+	Error         string
+	ErrorCategory string
 }
 
 type Client struct {
@@ -34,8 +45,21 @@ type Client struct {
 	DisableUrlEncode bool
 	Analyze          bool
 	Retry            int
+	RetryDelay       time.Duration
+	RetryUnsafe      bool
 	ReadTimeout      time.Duration
 	MaxBodySize      int64
+	Limiter          *RateLimiter
+
+	//Transport replaces the scanning transport, retries and rate limits still apply
+	Transport http.RoundTripper
+	//Context cancels the requests of this client, nil is context.Background()
+	Context context.Context
+
+	//error categories of every failed attempt made by this client
+	Errors map[string]int
+	//attempts sent by this client, retries and redirects included
+	Requests int
 }
 
 func (c *Client) preReq() {
@@ -48,13 +72,22 @@ func (c *Client) preReq() {
 	}
 
 	c.middleware = &middleware{
-		transport:   http.Transport{},
+		transport:   &http.Transport{},
 		redirects:   nil,
 		analyzer:    false,
 		maxRetry:    c.Retry,
+		retryDelay:  c.RetryDelay,
+		retryAll:    c.RetryUnsafe,
 		readTimeout: c.ReadTimeout,
 		maxBodySize: c.MaxBodySize,
+		limiter:     c.Limiter,
+		base:        c.Transport,
 	}
+	if c.Errors == nil {
+		c.Errors = make(map[string]int)
+	}
+	c.middleware.errors = c.Errors
+	c.middleware.requests = &c.Requests
 
 	c.middleware.redirects = &redirects{Count: 0, Urls: nil, StatusCodes: nil}
 	if c.Session {
@@ -71,41 +104,80 @@ func (c *Client) preReq() {
 	}
 }
 
-func (c *Client) req(req *http.Request) Response {
+func (c *Client) req(req *http.Request, maxBodySize int64) Response {
 	r := Response{Success: false}
-	resp, err := c.httpClient.Do(req)
 
-	if err != nil || resp == nil {
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+
+	if err != nil {
+		r.Error = err.Error()
+		r.ErrorCategory = Classify(err)
+		logger.Debug("request failed", "url", req.URL.String(), "class", r.ErrorCategory, "err", err)
 		return r
 	}
+	if resp == nil {
+		return r
+	}
+	defer resp.Body.Close()
 
-	r.StatusCode = resp.StatusCode
+	//cancelling the request context aborts a body read that takes too long
+	deadline := time.AfterFunc(c.ReadTimeout, cancel)
+	r = FromResponse(resp, maxBodySize)
+	deadline.Stop()
 	r.Redirects = *c.middleware.redirects
-	r.Success = true
+	c.middleware.analyze(&r)
+	return r
+}
+
+// FromResponse reads, decodes and converts to utf-8 at most maxBodySize bytes
+// of resp's body, it does not close it.
+func FromResponse(resp *http.Response, maxBodySize int64) Response {
+	r := Response{Success: true}
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	r.StatusCode = resp.StatusCode
 	r.Headers = resp.Header
 	r.Cookies = resp.Cookies()
-	r.Path = resp.Request.URL.Path
+	if resp.Request != nil && resp.Request.URL != nil {
+		r.URL = resp.Request.URL.String()
+		r.Path = resp.Request.URL.Path
+	}
 	r.Status = resp.Status
 	r.Proto = resp.Proto
-	if resp.TLS != nil {
+	r.ContentLength = resp.ContentLength
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
 		r.CommonName = resp.TLS.PeerCertificates[0].Subject.CommonName
 	}
-	var body []byte
-	var readDone = make(chan int)
 
-	go func() {
-		body, _ = ioutil.ReadAll(io.LimitReader(resp.Body, c.MaxBodySize))
-		readDone <- 1
-	}()
-
-	select {
-	case <-time.After(c.ReadTimeout):
-		//deadline
-	case <-readDone:
+	var body []byte
+	var err error
+	if resp.Body != nil && resp.Body != http.NoBody {
+		var decoded io.Reader
+		decoded, err = decodeBody(resp.Body, resp.Header.Get("Content-Encoding"))
+		if err == nil {
+			body, r.Truncated, err = readBody(decoded, maxBodySize)
+		}
 	}
+	if err != nil {
+		//keep what we got, but it is not the whole body
+		r.Truncated = true
+		logger.Debug("body read failed", "url", r.URL, "err", err)
+	}
+
+	body, r.Charset = toUTF8(body, resp.Header.Get("Content-Type"))
 	r.Text = string(body)
 	r.Size = len(body)
-	resp.Body.Close()
-	c.middleware.analyze(&r)
 	return r
 }
+
+// readBody reads at most limit bytes and reports whether the body was longer.
+func readBody(body io.Reader, limit int64) ([]byte, bool, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if int64(len(b)) > limit {
+		return b[:limit], true, nil
+	}
+	return b, false, err
+}
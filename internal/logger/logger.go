@@ -0,0 +1,168 @@
+// Package logger is a small leveled logger writing one line per event,
+// either logfmt like text or json, with key/value context.
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel reads debug, info, warn or error, "" is info
+func ParseLevel(s string) (Level, error) {
+	if s == "" {
+		return LevelInfo, nil
+	}
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+var (
+	mu     sync.Mutex
+	level  = LevelInfo
+	asJSON bool
+	out    io.Writer = os.Stderr
+	// the log file opened by Configure
+	logFile io.Closer
+)
+
+// Configure sets the level, the format (text or json) and the file to append
+// to, an empty file logs to stderr
+func Configure(lvl string, format string, file string) error {
+	l, err := ParseLevel(lvl)
+	if err != nil {
+		return err
+	}
+	if format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	var w io.Writer = os.Stderr
+	var f *os.File
+	if file != "" {
+		if f, err = os.OpenFile(file, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600); err != nil {
+			return err
+		}
+		w = f
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	closeFile()
+	level, asJSON, out = l, format == "json", w
+	if f != nil {
+		logFile = f
+	}
+	return nil
+}
+
+// Close closes the log file, later events go to stderr
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	out = os.Stderr
+	return closeFile()
+}
+
+func closeFile() error {
+	if logFile == nil {
+		return nil
+	}
+	err := logFile.Close()
+	logFile = nil
+	return err
+}
+
+// SetOutput redirects the log, e.g. for a library user
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// Enabled reports whether events of level l are written, check it before
+// building expensive context
+func Enabled(l Level) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return l >= level
+}
+
+func Debug(msg string, kv ...interface{}) { log(LevelDebug, msg, kv) }
+func Info(msg string, kv ...interface{})  { log(LevelInfo, msg, kv) }
+func Warn(msg string, kv ...interface{})  { log(LevelWarn, msg, kv) }
+func Error(msg string, kv ...interface{}) { log(LevelError, msg, kv) }
+
+func log(l Level, msg string, kv []interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l < level {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if asJSON {
+		fields := make(map[string]interface{}, len(kv)/2+3)
+		for i := 0; i+1 < len(kv); i += 2 {
+			fields[fmt.Sprint(kv[i])] = value(kv[i+1])
+		}
+		fields["time"], fields["level"], fields["msg"] = now, l.String(), msg
+		b, err := json.Marshal(fields)
+		if err != nil {
+			b = []byte(strconv.Quote(err.Error()))
+		}
+		out.Write(append(b, '\n'))
+		return
+	}
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "time=%s level=%s msg=%s", now, l, quote(msg))
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(b, " %s=%s", kv[i], quote(fmt.Sprint(value(kv[i+1]))))
+	}
+	b.WriteByte('\n')
+	io.WriteString(out, b.String())
+}
+
+// errors and stringers are logged as their text
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case fmt.Stringer:
+		return t.String()
+	}
+	return v
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -1,3 +1,6 @@
+//go:build pcre
+// +build pcre
+
 package pcre
 
 //extend pcre lib
@@ -296,6 +299,15 @@ func (m *Matcher) Matches() bool {
 	return m.matches
 }
 
+// Returns the start and end of the whole match of the last successful
+// match, or nil if it failed.
+func (m *Matcher) Index() []int {
+	if !m.matches {
+		return nil
+	}
+	return []int{int(m.ovector[0]), int(m.ovector[1])}
+}
+
 // Returns the number of groups in the current pattern.
 func (m *Matcher) Groups() int {
 	return m.groups
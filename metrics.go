@@ -0,0 +1,161 @@
+package dblyzer
+
+import (
+	"dblyzer/internal/dbio"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// metrics counts what the workers did since start
+type metrics struct {
+	mu       sync.Mutex
+	start    time.Time
+	targets  map[string]int64
+	requests int64
+	errors   map[string]int64
+	apps     int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		start:   time.Now(),
+		targets: make(map[string]int64),
+		errors:  make(map[string]int64),
+	}
+}
+
+func (m *metrics) observe(rp *dbio.Report, requests int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.targets[rp.Status]++
+	m.requests += int64(requests)
+	m.apps += int64(len(rp.Apps))
+	for category, n := range rp.Errors {
+		m.errors[category] += int64(n)
+	}
+}
+
+// Queue is the fill of a channel or pool
+type Queue struct {
+	Len int `json:"len"`
+	Cap int `json:"cap"`
+}
+
+func queueOf(c chan dbio.Report) Queue {
+	return Queue{Len: len(c), Cap: cap(c)}
+}
+
+// Status is a snapshot of a running scan, served as /status
+type Status struct {
+	Uptime    float64          `json:"uptime_s"`
+	Targets   int64            `json:"targets"`
+	Rate      float64          `json:"targets_per_s"`
+	ByStatus  map[string]int64 `json:"targets_by_status"`
+	Requests  int64            `json:"requests"`
+	Errors    map[string]int64 `json:"errors"`
+	Apps      int64            `json:"apps"`
+	Queues    map[string]Queue `json:"queues"`
+	Analyzers Queue            `json:"analyzers"`
+	Rules     string           `json:"rules"`
+}
+
+func (this *engine) status() Status {
+	m := this.metrics
+	m.mu.Lock()
+	s := Status{
+		Uptime:   time.Since(m.start).Seconds(),
+		ByStatus: make(map[string]int64, len(m.targets)),
+		Requests: m.requests,
+		Errors:   make(map[string]int64, len(m.errors)),
+		Apps:     m.apps,
+	}
+	for status, n := range m.targets {
+		s.ByStatus[status] = n
+		s.Targets += n
+	}
+	for category, n := range m.errors {
+		s.Errors[category] = n
+	}
+	m.mu.Unlock()
+
+	if s.Uptime > 0 {
+		s.Rate = float64(s.Targets) / s.Uptime
+	}
+	s.Queues = map[string]Queue{
+		"receive":  {Len: len(this.in), Cap: cap(this.in)},
+		"schedule": {Len: len(this.queue), Cap: cap(this.queue)},
+	}
+	if this.out != nil {
+		s.Queues["report"] = queueOf(this.out)
+	}
+	if this.sink != nil {
+		s.Queues["sink"] = queueOf(this.sink)
+	}
+	s.Analyzers = this.w.utilization()
+	s.Rules = this.w.RulesVersion()
+	return s
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeMetrics writes s in the prometheus text format
+func writeMetrics(w io.Writer, s Status) {
+	fmt.Fprintf(w, "# HELP dblyzer_uptime_seconds Seconds since the scan started.\n# TYPE dblyzer_uptime_seconds gauge\n")
+	fmt.Fprintf(w, "dblyzer_uptime_seconds %g\n", s.Uptime)
+
+	fmt.Fprintf(w, "# HELP dblyzer_targets_total Targets reported, by status.\n# TYPE dblyzer_targets_total counter\n")
+	for _, status := range sortedKeys(s.ByStatus) {
+		fmt.Fprintf(w, "dblyzer_targets_total{status=%q} %d\n", status, s.ByStatus[status])
+	}
+
+	fmt.Fprintf(w, "# HELP dblyzer_requests_total HTTP requests sent, retries included.\n# TYPE dblyzer_requests_total counter\n")
+	fmt.Fprintf(w, "dblyzer_requests_total %d\n", s.Requests)
+
+	fmt.Fprintf(w, "# HELP dblyzer_request_errors_total Failed HTTP attempts, by error class.\n# TYPE dblyzer_request_errors_total counter\n")
+	for _, category := range sortedKeys(s.Errors) {
+		fmt.Fprintf(w, "dblyzer_request_errors_total{class=%q} %d\n", category, s.Errors[category])
+	}
+
+	fmt.Fprintf(w, "# HELP dblyzer_apps_detected_total Apps reported over all targets.\n# TYPE dblyzer_apps_detected_total counter\n")
+	fmt.Fprintf(w, "dblyzer_apps_detected_total %d\n", s.Apps)
+
+	queues := make([]string, 0, len(s.Queues))
+	for name := range s.Queues {
+		queues = append(queues, name)
+	}
+	sort.Strings(queues)
+	fmt.Fprintf(w, "# HELP dblyzer_queue_length Items waiting in a queue.\n# TYPE dblyzer_queue_length gauge\n")
+	for _, name := range queues {
+		fmt.Fprintf(w, "dblyzer_queue_length{queue=%q} %d\n", name, s.Queues[name].Len)
+	}
+	fmt.Fprintf(w, "# HELP dblyzer_queue_capacity Size of a queue.\n# TYPE dblyzer_queue_capacity gauge\n")
+	for _, name := range queues {
+		fmt.Fprintf(w, "dblyzer_queue_capacity{queue=%q} %d\n", name, s.Queues[name].Cap)
+	}
+
+	fmt.Fprintf(w, "# HELP dblyzer_analyzers_busy Wappalyzer analyses running.\n# TYPE dblyzer_analyzers_busy gauge\n")
+	fmt.Fprintf(w, "dblyzer_analyzers_busy %d\n", s.Analyzers.Len)
+	fmt.Fprintf(w, "# HELP dblyzer_analyzers Limit of concurrent analyses, 0 when unbounded.\n# TYPE dblyzer_analyzers gauge\n")
+	fmt.Fprintf(w, "dblyzer_analyzers %d\n", s.Analyzers.Cap)
+}
+
+// progressLine is the one line summary printed to stderr while scanning
+func progressLine(s Status) string {
+	var errors int64
+	for _, n := range s.Errors {
+		errors += n
+	}
+	return fmt.Sprintf("progress: %d targets (%.1f/s, %d ok), %d requests, %d errors, %d apps, queue %d/%d, analyzing %d",
+		s.Targets, s.Rate, s.ByStatus[dbio.StatusOK], s.Requests, errors, s.Apps,
+		s.Queues["schedule"].Len, s.Queues["schedule"].Cap, s.Analyzers.Len)
+}
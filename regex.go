@@ -0,0 +1,150 @@
+package dblyzer
+
+import (
+	"dblyzer/internal/logger"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/dlclark/regexp2"
+)
+
+const backtrackTimeout = 100 * time.Millisecond
+
+// matcher is a compiled apps.json pattern
+type matcher interface {
+	FindAllStringSubmatch(s string, n int) [][]string
+}
+
+// regexBackend compiles patterns for one regex engine
+type regexBackend interface {
+	Name() string
+	Compile(pattern string) (matcher, error)
+}
+
+var regexBackends = map[string]regexBackend{
+	"re2":       re2Backend{},
+	"backtrack": backtrackBackend{},
+}
+
+type re2Backend struct{}
+
+func (re2Backend) Name() string { return "re2" }
+
+func (re2Backend) Compile(pattern string) (matcher, error) {
+	return regexp.Compile(pattern)
+}
+
+// backtrackBackend is a pure go engine with lookarounds and backreferences,
+// it parses in ecmascript mode since apps.json patterns are javascript regexes
+type backtrackBackend struct{}
+
+func (backtrackBackend) Name() string { return "backtrack" }
+
+func (backtrackBackend) Compile(pattern string) (matcher, error) {
+	re, err := regexp2.Compile(pattern, regexp2.ECMAScript)
+	if err != nil {
+		return nil, err
+	}
+	re.MatchTimeout = backtrackTimeout
+	return backtrackRegexp{re}, nil
+}
+
+type backtrackRegexp struct {
+	re *regexp2.Regexp
+}
+
+func (r backtrackRegexp) FindAllStringSubmatch(s string, n int) [][]string {
+	var all [][]string
+	m, err := r.re.FindStringMatch(s)
+	for m != nil && err == nil && (n < 0 || len(all) < n) {
+		groups := m.Groups()
+		sub := make([]string, len(groups))
+		for i, g := range groups {
+			if len(g.Captures) > 0 {
+				sub[i] = g.String()
+			}
+		}
+		all = append(all, sub)
+		m, err = r.re.FindNextMatch(m)
+	}
+	return all
+}
+
+// PatternIssue is a pattern that could not be compiled by the primary backend
+type PatternIssue struct {
+	App     string
+	Field   string
+	Pattern string
+	Backend string
+	Err     string
+}
+
+// CompileReport lists the patterns that fell back to another backend and those that failed outright
+type CompileReport struct {
+	Total    int
+	Fallback []PatternIssue
+	Failed   []PatternIssue
+}
+
+// Log writes the summary at info level and every pattern that fell back or failed
+func (r *CompileReport) Log() {
+	logger.Info("rules compiled", "patterns", r.Total, "fallback", len(r.Fallback), "failed", len(r.Failed))
+	for _, p := range r.Fallback {
+		logger.Info("pattern fell back", "rule", p.App+"."+p.Field, "backend", p.Backend, "pattern", p.Pattern, "err", p.Err)
+	}
+	for _, p := range r.Failed {
+		logger.Warn("pattern does not compile", "rule", p.App+"."+p.Field, "pattern", p.Pattern, "err", p.Err)
+	}
+}
+
+// regexCompiler tries the primary backend first, then the fallback one
+type regexCompiler struct {
+	primary  regexBackend
+	fallback regexBackend
+	report   CompileReport
+}
+
+// fallback is a name from regexBackends, "" or "none" disables it
+func newRegexCompiler(fallback string) (*regexCompiler, error) {
+	c := &regexCompiler{primary: re2Backend{}}
+	if fallback == "" || fallback == "none" {
+		return c, nil
+	}
+	backend, ok := regexBackends[fallback]
+	if !ok {
+		if fallback == "pcre" {
+			return nil, fmt.Errorf("regex_fallback pcre needs a build with -tags pcre")
+		}
+		names := make([]string, 0, len(regexBackends))
+		for name := range regexBackends {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown regex_fallback %q, have %v or none", fallback, names)
+	}
+	c.fallback = backend
+	return c, nil
+}
+
+func (c *regexCompiler) compile(app string, field string, pattern string) (matcher, bool) {
+	c.report.Total++
+	m, err := c.primary.Compile(pattern)
+	if err == nil {
+		return m, true
+	}
+
+	issue := PatternIssue{App: app, Field: field, Pattern: pattern, Err: err.Error()}
+	if c.fallback != nil {
+		m, ferr := c.fallback.Compile(pattern)
+		if ferr == nil {
+			issue.Backend = c.fallback.Name()
+			c.report.Fallback = append(c.report.Fallback, issue)
+			return m, true
+		}
+		issue.Err = ferr.Error()
+	}
+	c.report.Failed = append(c.report.Failed, issue)
+	return nil, false
+}
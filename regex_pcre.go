@@ -0,0 +1,59 @@
+//go:build pcre
+// +build pcre
+
+package dblyzer
+
+import "dblyzer/internal/pcre"
+
+// build with -tags pcre (needs libpcre3-dev) to use libpcre as fallback backend
+func init() {
+	regexBackends["pcre"] = pcreBackend{}
+}
+
+type pcreBackend struct{}
+
+func (pcreBackend) Name() string { return "pcre" }
+
+func (pcreBackend) Compile(pattern string) (matcher, error) {
+	re, err := pcre.Compile(pattern, 0)
+	if err != nil {
+		return nil, pcreError{err}
+	}
+	return pcreRegexp{re}, nil
+}
+
+type pcreError struct {
+	err *pcre.CompileError
+}
+
+func (e pcreError) Error() string {
+	return e.err.String()
+}
+
+type pcreRegexp struct {
+	re pcre.Regexp
+}
+
+func (r pcreRegexp) FindAllStringSubmatch(s string, n int) [][]string {
+	var all [][]string
+	m := r.re.MatcherString(s, 0)
+	for m.Matches() && (n < 0 || len(all) < n) {
+		sub := make([]string, m.Groups()+1)
+		for i := range sub {
+			sub[i] = m.GroupString(i)
+		}
+		all = append(all, sub)
+
+		//continue after the match, skip one byte on empty matches
+		end := m.Index()[1]
+		if end == m.Index()[0] {
+			end++
+		}
+		if end > len(s) {
+			break
+		}
+		s = s[end:]
+		m.MatchString(s, 0)
+	}
+	return all
+}
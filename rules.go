@@ -0,0 +1,283 @@
+package dblyzer
+
+import (
+	"crypto/sha256"
+	"dblyzer/internal/dbio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// rawRules is the merge of every rule source before apps are decoded.
+// Sources are applied in order, an app or category defined again by a
+// later source replaces the earlier definition as a whole.
+type rawRules struct {
+	Apps   map[string]json.RawMessage
+	Cats   map[string]json.RawMessage
+	Origin map[string]string
+}
+
+// loadRawRules reads rule sources, each one is either a legacy apps.json file
+// ({"apps":…,"categories":…}) or a directory in the split wappalyzer layout
+// (categories.json plus technologies/*.json, or *.json when there is no
+// technologies directory).
+func loadRawRules(sources []string) (*rawRules, error) {
+	rules := &rawRules{
+		Apps:   make(map[string]json.RawMessage),
+		Cats:   make(map[string]json.RawMessage),
+		Origin: make(map[string]string),
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no rule source")
+	}
+	for _, source := range sources {
+		fi, err := os.Stat(source)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			err = rules.loadDir(source)
+		} else {
+			err = rules.loadLegacy(source)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+func (r *rawRules) loadLegacy(filePath string) error {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	var legacy struct {
+		Apps map[string]json.RawMessage `json:"apps"`
+		Cats map[string]json.RawMessage `json:"categories"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return fmt.Errorf("%s: %s", filePath, err)
+	}
+	for name, raw := range legacy.Apps {
+		r.Apps[name] = raw
+		r.Origin[name] = filePath
+	}
+	for id, raw := range legacy.Cats {
+		r.Cats[id] = raw
+	}
+	return nil
+}
+
+func (r *rawRules) loadDir(dir string) error {
+	categories := filepath.Join(dir, "categories.json")
+	if _, err := os.Stat(categories); err == nil {
+		data, err := ioutil.ReadFile(categories)
+		if err != nil {
+			return err
+		}
+		var cats map[string]json.RawMessage
+		if err := json.Unmarshal(data, &cats); err != nil {
+			return fmt.Errorf("%s: %s", categories, err)
+		}
+		for id, raw := range cats {
+			r.Cats[id] = raw
+		}
+	}
+
+	techDir := filepath.Join(dir, "technologies")
+	if fi, err := os.Stat(techDir); err != nil || !fi.IsDir() {
+		techDir = dir
+	}
+	files, err := filepath.Glob(filepath.Join(techDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		if file == categories {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var techs map[string]json.RawMessage
+		if err := json.Unmarshal(data, &techs); err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		for name, raw := range techs {
+			app, err := fromTechnology(raw)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %s", file, name, err)
+			}
+			r.Apps[name] = app
+			r.Origin[name] = file
+		}
+	}
+	return nil
+}
+
+// fromTechnology maps the fields renamed by the split layout to the apps.json ones
+func fromTechnology(raw json.RawMessage) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	if src, ok := fields["scriptSrc"]; ok {
+		if _, ok := fields["script"]; !ok {
+			fields["script"] = src
+		}
+		delete(fields, "scriptSrc")
+	}
+	return json.Marshal(fields)
+}
+
+// version hashes the merged rules, names are sorted so it only changes with the content
+func (r *rawRules) version() string {
+	h := sha256.New()
+	for _, m := range []map[string]json.RawMessage{r.Cats, r.Apps} {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			h.Write([]byte(k))
+			h.Write([]byte{0})
+			h.Write(m[k])
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// ruleSet is a compiled, immutable set of rules
+type ruleSet struct {
+	appDefs *appsDefinition
+	report  CompileReport
+	version string
+}
+
+func compileRuleSet(sources []string, regexFallback string) (*ruleSet, error) {
+	rules, err := loadRawRules(sources)
+	if err != nil {
+		return nil, err
+	}
+	appDefs, err := decodeAppsDefinition(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := newRegexCompiler(regexFallback)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range appDefs.Apps {
+
+		app := appDefs.Apps[key]
+
+		app.hTMLRegex = compileRegexes(rc, key, "html", value.HTML)
+		app.scriptRegex = compileRegexes(rc, key, "script", value.Script)
+		app.uRLRegex = compileRegexes(rc, key, "url", value.URL)
+
+		app.headerRegex = compileNamedRegexes(rc, key, "headers", app.Headers)
+		app.metaRegex = compileNamedRegexes(rc, key, "meta", app.Meta)
+		app.cookieRegex = compileNamedRegexes(rc, key, "cookies", app.Cookies)
+
+		app.categories = make([]dbio.Category, 0, len(app.Cats))
+
+		for _, cid := range app.Cats {
+			if category, ok := appDefs.Cats[string(cid)]; ok && category.Name != "" {
+				id, _ := strconv.Atoi(cid)
+				app.categories = append(app.categories, dbio.Category{
+					ID:       id,
+					Name:     category.Name,
+					Priority: category.Priority,
+				})
+			}
+		}
+
+		appDefs.Apps[key] = app
+		appDefs.names = append(appDefs.names, key)
+	}
+	sort.Strings(appDefs.names)
+
+	return &ruleSet{appDefs: appDefs, report: rc.report, version: rules.version()}, nil
+}
+
+func decodeAppsDefinition(rules *rawRules) (*appsDefinition, error) {
+	appDefs := &appsDefinition{
+		Apps: make(map[string]app, len(rules.Apps)),
+		Cats: make(map[string]category, len(rules.Cats)),
+	}
+	for name, raw := range rules.Apps {
+		var a app
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", rules.Origin[name], name, err)
+		}
+		appDefs.Apps[name] = a
+	}
+	for id, raw := range rules.Cats {
+		var c category
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, fmt.Errorf("category %s: %s", id, err)
+		}
+		appDefs.Cats[id] = c
+	}
+	return appDefs, nil
+}
+
+// sourceFiles lists the files a source is read from, the same way loadRawRules does
+func sourceFiles(source string) []string {
+	fi, err := os.Stat(source)
+	if err != nil || !fi.IsDir() {
+		return []string{source}
+	}
+	files := []string{filepath.Join(source, "categories.json")}
+	techDir := filepath.Join(source, "technologies")
+	if fi, err := os.Stat(techDir); err != nil || !fi.IsDir() {
+		techDir = source
+	}
+	techs, _ := filepath.Glob(filepath.Join(techDir, "*.json"))
+	return append(files, techs...)
+}
+
+// fingerprint changes when a rule file is added, removed or modified
+func fingerprint(sources []string) string {
+	h := sha256.New()
+	for _, source := range sources {
+		for _, file := range sourceFiles(source) {
+			fi, err := os.Stat(file)
+			if err != nil {
+				fmt.Fprintf(h, "%s:-\n", file)
+				continue
+			}
+			fmt.Fprintf(h, "%s:%d:%d\n", file, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// watchRules polls the rule sources and calls reload when they change
+func watchRules(sources []string, interval time.Duration, reload func()) {
+	go func() {
+		last := fingerprint(sources)
+		for {
+			time.Sleep(interval)
+			current := fingerprint(sources)
+			if current == last {
+				continue
+			}
+			last = current
+			reload()
+		}
+	}()
+}
@@ -0,0 +1,48 @@
+package dblyzer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchRules(t *testing.T) {
+	dir := t.TempDir()
+	rules := filepath.Join(dir, "apps.json")
+	writeRules(t, rules, false)
+
+	reloads := make(chan struct{}, 16)
+	watchRules([]string{dir}, 5*time.Millisecond, func() { reloads <- struct{}{} })
+
+	expect := func(what string, want bool) {
+		t.Helper()
+		select {
+		case <-reloads:
+			if !want {
+				t.Fatalf("reload %s", what)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if want {
+				t.Fatalf("no reload %s", what)
+			}
+		}
+	}
+	expect("without a change", false)
+
+	writeRules(t, rules, true)
+	expect("after a rule file changed", true)
+	expect("twice for one change", false)
+
+	extra := filepath.Join(dir, "extra.json")
+	if err := ioutil.WriteFile(extra, []byte(`{"apps": {}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expect("after a rule file was added", true)
+
+	if err := os.Remove(extra); err != nil {
+		t.Fatal(err)
+	}
+	expect("after a rule file was removed", true)
+}
@@ -0,0 +1,185 @@
+package dblyzer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"dblyzer/internal/dbio"
+	"dblyzer/internal/httpclient"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type Category = dbio.Category
+type Evidence = dbio.Evidence
+
+// Target is a host and port to scan, as dbgrab reports it
+type Target = dbio.Receive
+
+// Detection is an app found in a response
+type Detection struct {
+	Name       string     `json:"name"`
+	Version    string     `json:"version,omitempty"`
+	Confidence int        `json:"confidence,omitempty"`
+	Categories []Category `json:"categories,omitempty"`
+	Implies    []string   `json:"implies,omitempty"`
+	Evidence   []Evidence `json:"evidence,omitempty"`
+}
+
+// Option configures a Scanner
+type Option func(*options)
+
+// WithRules sets the rule sources, apps.json files or technologies
+// directories, later sources override apps of the same name. Default ./apps.json.
+func WithRules(sources ...string) Option {
+	return func(o *options) { o.rules = sources }
+}
+
+// WithRegexFallback sets the engine for patterns re2 rejects: backtrack (default), pcre or none.
+func WithRegexFallback(name string) Option {
+	return func(o *options) { o.fallback = name }
+}
+
+// WithHTTPClient sends the scan requests through c's Transport, e.g. for a
+// proxy. Only the Transport is used: c's Timeout, Jar and CheckRedirect are
+// ignored, bound a scan with its ctx instead. Redirects, retries and rate
+// limits are still handled by the Scanner.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.transport = c.Transport
+		if o.transport == nil {
+			o.transport = http.DefaultTransport
+		}
+	}
+}
+
+// WithProbes sets the paths fetched after the root page, its redirect and
+// the favicon. Default "/console", none when called without paths.
+func WithProbes(paths ...string) Option {
+	return func(o *options) { o.probes = paths }
+}
+
+// WithEvidence sets the evidence kept per app: off, summary or full (default).
+func WithEvidence(level string) Option {
+	return func(o *options) { o.evidence = level }
+}
+
+// WithCategories keeps only the apps in one of the categories, given by name
+// or id. Default all.
+func WithCategories(names ...string) Option {
+	return func(o *options) { o.categories = names }
+}
+
+// Scanner fingerprints responses and targets without the channel pipeline.
+// It is safe for concurrent use.
+type Scanner struct {
+	e *engine
+}
+
+func NewScanner(opts ...Option) (*Scanner, error) {
+	o := options{
+		rules:    []string{"./apps.json"},
+		fallback: "backtrack",
+		probes:   defaultProbes,
+		evidence: "full",
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	e, err := buildEngine(o)
+	if err != nil {
+		return nil, err
+	}
+	return &Scanner{e: e}, nil
+}
+
+// Analyze reads resp's body, without closing it, and returns the apps found
+// in the response.
+func (s *Scanner) Analyze(ctx context.Context, resp *http.Response) ([]Detection, error) {
+	return s.analyze(ctx, httpclient.FromResponse(resp, 0))
+}
+
+// AnalyzeRaw parses raw, an http response with status line, headers and
+// body like a dbgrab banner, fetched from url, and returns the apps found in it.
+func (s *Scanner) AnalyzeRaw(ctx context.Context, rawurl string, raw []byte) ([]Detection, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return s.Analyze(ctx, resp)
+}
+
+func (s *Scanner) analyze(ctx context.Context, r httpclient.Response) ([]Detection, error) {
+	res, err := s.e.w.Analyze(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	var rp dbio.Report
+	s.e.mergeApps(&rp, res, make(seenPatterns))
+	detections := make([]Detection, 0, len(rp.Apps))
+	for _, app := range rp.Apps {
+		detections = append(detections, Detection{
+			Name:       app.AppName,
+			Version:    app.Version,
+			Confidence: app.Confidence,
+			Categories: app.Categories,
+			Implies:    app.Implies,
+			Evidence:   app.Evidence,
+		})
+	}
+	return detections, nil
+}
+
+// Scan probes the target and reports its apps, favicon and domains. A target
+// that does not answer is a report with status failed, the error is only set
+// when ctx ends the scan.
+func (s *Scanner) Scan(ctx context.Context, target Target) (Report, error) {
+	if err := ctx.Err(); err != nil {
+		return Report{}, err
+	}
+	rp := s.e.scan(ctx, target)
+	return rp, ctx.Err()
+}
+
+// Reload recompiles the rule sources given to NewScanner, the same way as the
+// pipeline's Reload. Scan and Analyze calls already running keep their rules.
+func (s *Scanner) Reload() error {
+	return s.e.reloadRules()
+}
+
+// RulesVersion is the hash of the rules in use.
+func (s *Scanner) RulesVersion() string {
+	return s.e.w.RulesVersion()
+}
+
+// ParseTarget turns an http or https url into a Target
+func ParseTarget(rawurl string) (Target, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return Target{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Target{}, fmt.Errorf("%s: not an http or https url", rawurl)
+	}
+	t := Target{Host: u.Hostname(), Service: u.Scheme, Port: 80}
+	if u.Scheme == "https" {
+		t.Port = 443
+	}
+	if p := u.Port(); p != "" {
+		if t.Port, err = strconv.Atoi(p); err != nil {
+			return Target{}, fmt.Errorf("%s: bad port", rawurl)
+		}
+	}
+	if net.ParseIP(t.Host) != nil {
+		t.Ip = t.Host
+	}
+	return t, nil
+}
@@ -0,0 +1,160 @@
+package dblyzer
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func testScanner(t *testing.T, opts ...Option) *Scanner {
+	s, err := NewScanner(append([]Option{WithRules(testRules)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func detected(list []Detection) map[string]string {
+	versions := make(map[string]string, len(list))
+	for _, d := range list {
+		versions[d.Name] = d.Version
+	}
+	return versions
+}
+
+func TestScannerAnalyze(t *testing.T) {
+	srv := newTestSite(t)
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	got, err := testScanner(t).Analyze(context.Background(), resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := detected(got)
+	for name, version := range map[string]string{"Nginx": "", "PHP": "7.4.3", "WordPress": "5.8", "MySQL": "", "jQuery": "1.12.4", "Backref": ""} {
+		if v, ok := apps[name]; !ok || v != version {
+			t.Errorf("%s: got %q (found %v), want %q", name, v, ok, version)
+		}
+	}
+	for _, d := range got {
+		if len(d.Evidence) == 0 || d.Confidence != 100 {
+			t.Errorf("%s: confidence %d with %d evidence", d.Name, d.Confidence, len(d.Evidence))
+		}
+	}
+}
+
+func TestScannerAnalyzeRaw(t *testing.T) {
+	raw := []byte("HTTP/1.1 200 OK\r\nServer: nginx/1.18.0\r\nContent-Type: text/html\r\n\r\n" +
+		`<html><head><meta name="generator" content="WordPress 5.8"></head></html>`)
+	got, err := testScanner(t, WithEvidence("off")).AnalyzeRaw(context.Background(), "http://example.com/", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := detected(got)
+	if apps["Nginx"] != "1.18.0" || apps["WordPress"] != "5.8" {
+		t.Errorf("got %+v", got)
+	}
+	if _, ok := apps["PHP"]; !ok {
+		t.Errorf("PHP implied by WordPress missing: %+v", got)
+	}
+	for _, d := range got {
+		if d.Evidence != nil {
+			t.Errorf("%s: evidence %+v with evidence off", d.Name, d.Evidence)
+		}
+	}
+
+	if _, err := testScanner(t).AnalyzeRaw(context.Background(), "http://example.com/", []byte("SSH-2.0-OpenSSH\r\n")); err == nil {
+		t.Error("no error for a response that is not http")
+	}
+}
+
+func TestScannerCategories(t *testing.T) {
+	raw := []byte("HTTP/1.1 200 OK\r\nServer: nginx/1.18.0\r\nContent-Type: text/html\r\n\r\n" +
+		`<html><head><meta name="generator" content="WordPress 5.8"></head></html>`)
+	got, err := testScanner(t, WithCategories("cms", " Web Servers ", "34")).AnalyzeRaw(context.Background(), "http://example.com/", raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := detected(got)
+	if len(apps) != 3 || apps["Nginx"] != "1.18.0" || apps["WordPress"] != "5.8" {
+		t.Errorf("got %+v, want Nginx, WordPress and MySQL", got)
+	}
+	if _, ok := apps["MySQL"]; !ok {
+		t.Errorf("MySQL, category 34, missing: %+v", got)
+	}
+}
+
+// countingTransport counts the requests per path
+type countingTransport struct {
+	mu    sync.Mutex
+	paths map[string]int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.paths[req.URL.Path]++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestScannerScan(t *testing.T) {
+	srv := newTestSite(t)
+	transport := &countingTransport{paths: make(map[string]int)}
+	s := testScanner(t, WithHTTPClient(&http.Client{Transport: transport}), WithProbes("/admin"))
+
+	target, err := ParseTarget(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := s.Scan(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Status != StatusOK || rp.Rules != s.RulesVersion() {
+		t.Fatalf("status %s, rules %s, error %+v", rp.Status, rp.Rules, rp.Error)
+	}
+	if app := rp.GetApp("Nginx"); app == nil || app.Version != "1.19.0" {
+		t.Errorf("nginx %+v, want the version of the /admin probe", app)
+	}
+	if transport.paths["/admin"] != 1 || transport.paths["/console"] != 0 {
+		t.Errorf("requests %v, want /admin probed instead of /console", transport.paths)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Scan(ctx, target); err != context.Canceled {
+		t.Errorf("err %v, want context.Canceled", err)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    Target
+		wantErr bool
+	}{
+		{"http://example.com", Target{Host: "example.com", Port: 80, Service: "http"}, false},
+		{"https://example.com/path", Target{Host: "example.com", Port: 443, Service: "https"}, false},
+		{"http://example.com:8080", Target{Host: "example.com", Port: 8080, Service: "http"}, false},
+		{"https://10.0.0.1:8443", Target{Host: "10.0.0.1", Ip: "10.0.0.1", Port: 8443, Service: "https"}, false},
+		{"http://[::1]", Target{Host: "::1", Ip: "::1", Port: 80, Service: "http"}, false},
+		{"ftp://example.com", Target{}, true},
+		{"example.com", Target{}, true},
+		{"http://example.com:http", Target{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTarget(%q) err %v, want error %v", tt.url, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, want %+v", tt.url, got, tt.want)
+		}
+	}
+}
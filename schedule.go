@@ -0,0 +1,128 @@
+package dblyzer
+
+import (
+	"dblyzer/internal/dbio"
+	"dblyzer/internal/httpclient"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// targets read ahead of the workers, an https endpoint in here counts as queued
+const lookahead = 1024
+
+// https endpoints remembered for their http twins, the oldest are forgotten first
+const httpsMemory = 1 << 16
+
+// scheduler remembers the https endpoints handed to the workers so an http
+// port that only redirects to one of them is linked instead of scanned twice
+type scheduler struct {
+	mu    sync.Mutex
+	https map[string]bool
+	order []string
+	max   int
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{https: make(map[string]bool), max: httpsMemory}
+}
+
+func endpointKey(host string, port int) string {
+	return strings.ToLower(host) + ":" + strconv.Itoa(port)
+}
+
+func (s *scheduler) add(rc dbio.Receive, scheme string) {
+	if scheme != "https" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, host := range []string{rc.Host, rc.Ip} {
+		key := endpointKey(host, rc.Port)
+		if host == "" || s.https[key] {
+			continue
+		}
+		s.https[key] = true
+		s.order = append(s.order, key)
+		if len(s.order) > s.max {
+			delete(s.https, s.order[0])
+			s.order = s.order[1:]
+		}
+	}
+}
+
+func (s *scheduler) queued(host string, port int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.https[endpointKey(host, port)]
+}
+
+// schedule moves the targets from in to the workers' queue, registering
+// the https ones on the way, and closes the queue when in is closed
+func (this *engine) schedule() {
+	go func() {
+		for rc := range this.in {
+			this.scheduler.add(rc, this.services.scheme(rc.Service))
+			this.queue <- rc
+		}
+		close(this.queue)
+	}()
+}
+
+// httpsTwin returns the https endpoint the http response r of rc redirects
+// to, when that endpoint is on the same host and queued as well
+func (this *engine) httpsTwin(rc dbio.Receive, r httpclient.Response) string {
+	if r.StatusCode < 300 || r.StatusCode > 399 {
+		return ""
+	}
+	u, err := url.Parse(r.Headers.Get("Location"))
+	if err != nil || u.Scheme != "https" {
+		return ""
+	}
+	host := u.Hostname()
+	if !strings.EqualFold(host, rc.Host) && host != rc.Ip {
+		return ""
+	}
+	port := 443
+	if p := u.Port(); p != "" {
+		if port, err = strconv.Atoi(p); err != nil {
+			return ""
+		}
+	}
+	if !this.scheduler.queued(host, port) {
+		return ""
+	}
+	return getURL(dbio.Receive{Service: "https", Host: host, Port: port})
+}
+
+// favicon hashes by url, shared by all workers
+type faviconCache struct {
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+// past this many urls the cache starts over
+const faviconCacheSize = 1 << 16
+
+func newFaviconCache() *faviconCache {
+	return &faviconCache{hashes: make(map[string]string)}
+}
+
+func (c *faviconCache) get(url string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hash, ok := c.hashes[url]
+	return hash, ok
+}
+
+func (c *faviconCache) put(hash string, urls ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.hashes) >= faviconCacheSize {
+		c.hashes = make(map[string]string)
+	}
+	for _, url := range urls {
+		c.hashes[url] = hash
+	}
+}
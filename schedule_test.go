@@ -0,0 +1,38 @@
+package dblyzer
+
+import (
+	"dblyzer/internal/dbio"
+	"fmt"
+	"testing"
+)
+
+func TestSchedulerForgetsOldest(t *testing.T) {
+	s := newScheduler()
+	s.max = 4
+	s.add(dbio.Receive{Host: "Old.example", Ip: "10.0.0.1", Port: 443}, "https")
+	s.add(dbio.Receive{Host: "plain.example", Port: 80}, "http")
+	if !s.queued("old.example", 443) || !s.queued("10.0.0.1", 443) || s.queued("plain.example", 80) {
+		t.Fatalf("got %v", s.https)
+	}
+	// queued again, it keeps its place
+	s.add(dbio.Receive{Host: "old.example", Ip: "10.0.0.1", Port: 443}, "https")
+	s.add(dbio.Receive{Host: "new.example", Port: 443}, "https")
+	if len(s.https) != 3 {
+		t.Fatalf("%d endpoints, want 3: %v", len(s.https), s.https)
+	}
+
+	for i := 0; i < 10; i++ {
+		s.add(dbio.Receive{Ip: fmt.Sprintf("10.0.1.%d", i), Port: 443}, "https")
+	}
+	if len(s.https) != 4 || len(s.order) != 4 {
+		t.Fatalf("%d endpoints, %d in order, want 4", len(s.https), len(s.order))
+	}
+	if s.queued("old.example", 443) || s.queued("new.example", 443) {
+		t.Error("the oldest endpoints are still remembered")
+	}
+	for i := 6; i < 10; i++ {
+		if !s.queued(fmt.Sprintf("10.0.1.%d", i), 443) {
+			t.Errorf("10.0.1.%d forgotten", i)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package dblyzer
+
+import "strings"
+
+// dbgrab/nmap service names that are known to speak http(s)
+var defaultServiceSchemes = map[string]string{
+	"http":           "http",
+	"http-alt":       "http",
+	"http-proxy":     "http",
+	"http-mgmt":      "http",
+	"https":          "https",
+	"https-alt":      "https",
+	"https-proxy":    "https",
+	"https-mgmt":     "https",
+	"ssl/http":       "https",
+	"ssl/https":      "https",
+	"ssl/http-alt":   "https",
+	"ssl/http-proxy": "https",
+	"ssl/https-alt":  "https",
+	"tls/http":       "https",
+	"tls/https":      "https",
+	"ssl/unknown":    "",
+	"tcpwrapped":     "",
+	"http-rpc-epmap": "",
+	"ncacn_http":     "",
+	"ssl/ncacn_http": "",
+}
+
+// serviceClassifier maps a service name to the scheme used to probe it,
+// "" means the service is not http.
+type serviceClassifier map[string]string
+
+// newServiceClassifier layers the configured table over the default one,
+// a configured "" scheme disables a service.
+func newServiceClassifier(table map[string]string) serviceClassifier {
+	c := make(serviceClassifier, len(defaultServiceSchemes)+len(table))
+	for name, scheme := range defaultServiceSchemes {
+		c[name] = scheme
+	}
+	for name, scheme := range table {
+		c[strings.ToLower(strings.TrimSpace(name))] = strings.ToLower(strings.TrimSpace(scheme))
+	}
+	return c
+}
+
+func (c serviceClassifier) scheme(service string) string {
+	name := strings.ToLower(strings.TrimSpace(service))
+	if scheme, ok := c[name]; ok {
+		return scheme
+	}
+
+	//unknown names fall back to prefix rules: https*, ssl*http*, tls*http*, http*
+	switch {
+	case strings.HasPrefix(name, "https"):
+		return "https"
+	case strings.HasPrefix(name, "ssl") && strings.Contains(name[3:], "http"):
+		return "https"
+	case strings.HasPrefix(name, "tls") && strings.Contains(name[3:], "http"):
+		return "https"
+	case strings.HasPrefix(name, "http"):
+		return "http"
+	}
+	return ""
+}
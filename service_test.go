@@ -0,0 +1,38 @@
+package dblyzer
+
+import "testing"
+
+func TestServiceClassifierScheme(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   map[string]string
+		service string
+		want    string
+	}{
+		{"http", nil, "http", "http"},
+		{"ssl/http", nil, "ssl/http", "https"},
+		{"http-proxy", nil, "http-proxy", "http"},
+		{"https-alt", nil, "https-alt", "https"},
+		{"case and spaces", nil, " SSL/HTTP ", "https"},
+		{"not http", nil, "ssh", ""},
+		{"known not http", nil, "ncacn_http", ""},
+		{"empty", nil, "", ""},
+		{"unknown https prefix", nil, "https-custom", "https"},
+		{"unknown ssl http", nil, "ssl/http-custom", "https"},
+		{"unknown tls http", nil, "tls/httpx", "https"},
+		{"unknown http prefix", nil, "http-custom", "http"},
+		{"unknown ssl", nil, "ssl/imap", ""},
+		{"override default", map[string]string{"http-proxy": "https"}, "http-proxy", "https"},
+		{"override disables", map[string]string{"ssl/http": ""}, "ssl/http", ""},
+		{"override adds", map[string]string{" WebMin ": " HTTPS "}, "webmin", "https"},
+		{"override keeps others", map[string]string{"webmin": "https"}, "https-alt", "https"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newServiceClassifier(tt.table)
+			if got := c.scheme(tt.service); got != tt.want {
+				t.Errorf("scheme(%q) = %q, want %q", tt.service, got, tt.want)
+			}
+		})
+	}
+}
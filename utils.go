@@ -3,82 +3,111 @@ package dblyzer
 import (
 	"bytes"
 	"dblyzer/internal/dbio"
-	"dblyzer/internal/pcre"
+	"dblyzer/internal/httpclient"
 	"fmt"
 	"mvdan.cc/xurls/v2"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
-var httpRegex, _ = pcre.Compile(`http`, pcre.ANCHORED)
-var httpsRegex, _ = pcre.Compile(`https|((?>ssl)(.*)(?>http))`, pcre.ANCHORED)
 var faviconRegex = regexp.MustCompile(`(?im)<link.*?icon.*?href=('|")(.*)('|")|<link.*?href=('|")(.*)('|").*?icon`)
 var linkRegex = regexp.MustCompile(`(?im)<link[^<]+href=('|")[^<]+>`)
 var httpLink = regexp.MustCompile(`http.*?`)
 var rxStrict = xurls.Strict()
 
-func compileRegexes(s stringArray) []appRegexp {
+func compileRegexes(rc *regexCompiler, appName string, field string, s stringArray) []appRegexp {
 	var list []appRegexp
 
 	for _, regexString := range s {
 
 		// Split version detection
-		splitted := strings.Split(regexString, "\\;")
+		pattern, version, confidence := parsePattern(regexString)
 
-		regex, err := regexp.Compile(splitted[0])
-		if err != nil {
-		} else {
-			rv := appRegexp{
-				Regexp: regex,
-			}
-
-			if len(splitted) > 1 && strings.HasPrefix(splitted[0], "version") {
-				rv.Version = splitted[1][8:]
-			}
-
-			list = append(list, rv)
+		regex, ok := rc.compile(appName, field, pattern)
+		if !ok {
+			continue
+		}
+		rv := appRegexp{
+			Regexp:     regex,
+			Pattern:    pattern,
+			Version:    version,
+			Confidence: confidence,
 		}
+
+		list = append(list, rv)
 	}
 
 	return list
 }
 
-func compileNamedRegexes(from map[string]string) []appRegexp {
+func compileNamedRegexes(rc *regexCompiler, appName string, field string, from map[string]stringArray) []appRegexp {
 
 	var list []appRegexp
 
-	for key, value := range from {
+	keys := make([]string, 0, len(from))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		values := from[key]
 
-		h := appRegexp{
-			Name: key,
+		if len(values) == 0 {
+			values = stringArray{""}
 		}
 
-		if value == "" {
-			value = ".*"
-		}
+		for _, value := range values {
+			h := appRegexp{
+				Name: key,
+			}
 
-		// Filter out webapplyzer attributes from regular expression
-		splitted := strings.Split(value, "\\;")
+			if value == "" {
+				value = ".*"
+			}
 
-		r, err := regexp.Compile(splitted[0])
-		if err != nil {
-			continue
-		}
+			// Filter out webapplyzer attributes from regular expression
+			pattern, version, confidence := parsePattern(value)
 
-		if len(splitted) > 1 && strings.HasPrefix(splitted[1], "version:") {
-			h.Version = splitted[1][8:]
-		}
+			r, ok := rc.compile(appName, field+"."+key, pattern)
+			if !ok {
+				continue
+			}
 
-		h.Regexp = r
-		list = append(list, h)
+			h.Regexp = r
+			h.Pattern = pattern
+			h.Version = version
+			h.Confidence = confidence
+			list = append(list, h)
+		}
 	}
 
 	return list
 }
 
+// parsePattern splits the wappalyzer attributes off a pattern,
+// e.g. "nginx/([\\d.]+)\\;version:\\1\\;confidence:50"
+func parsePattern(s string) (pattern string, version string, confidence int) {
+	confidence = 100
+	splitted := strings.Split(s, "\\;")
+	for _, attr := range splitted[1:] {
+		switch {
+		case strings.HasPrefix(attr, "version:"):
+			version = attr[8:]
+		case strings.HasPrefix(attr, "confidence:"):
+			if c, err := strconv.Atoi(attr[11:]); err == nil {
+				confidence = c
+			}
+		}
+	}
+	return splitted[0], version, confidence
+}
+
 func findVersion(matches [][]string, version string) string {
 	var v string
 
@@ -86,7 +115,7 @@ func findVersion(matches [][]string, version string) string {
 		// replace backtraces (max: 3)
 		for i := 1; i <= 3; i++ {
 			bt := fmt.Sprintf("\\%v", i)
-			if strings.Contains(version, bt) && len(matchPair) >= i {
+			if strings.Contains(version, bt) && len(matchPair) > i {
 				v = strings.Replace(version, bt, matchPair[i], 1)
 			}
 		}
@@ -101,9 +130,10 @@ func findVersion(matches [][]string, version string) string {
 	return ""
 }
 
-func findMatches(content string, regexes []appRegexp) ([][]string, string) {
+func findMatches(content string, regexes []appRegexp) ([][]string, string, []dbio.Evidence) {
 	var m [][]string
 	var version string
+	var evidence []dbio.Evidence
 
 	for _, r := range regexes {
 		matches := r.Regexp.FindAllStringSubmatch(content, -1)
@@ -112,26 +142,17 @@ func findMatches(content string, regexes []appRegexp) ([][]string, string) {
 		}
 
 		m = append(m, matches...)
+		evidence = append(evidence, dbio.Evidence{
+			Match:      matches[0][0],
+			Pattern:    r.Pattern,
+			Confidence: r.Confidence,
+		})
 
 		if r.Version != "" {
 			version = findVersion(m, r.Version)
 		}
 	}
-	return m, version
-}
-
-func isHTTP(serviceName string) bool {
-	if httpRegex.FindIndex([]byte(serviceName), 0) == nil {
-		return false
-	}
-	return true
-}
-
-func isHTTPS(serviceName string) bool {
-	if httpsRegex.FindIndex([]byte(serviceName), 0) == nil {
-		return false
-	}
-	return true
+	return m, version, evidence
 }
 
 func getFaviconlink(page string) string {
@@ -194,9 +215,123 @@ func getURL(rc dbio.Receive) string {
 func headerToString(m http.Header, proto string, status string) string {
 	b := new(bytes.Buffer)
 	fmt.Fprintf(b, "%s %s\n", proto, status)
-	for key, value := range m {
-		fmt.Fprintf(b, "%s:%s\n", key, value[0])
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(b, "%s:%s\n", key, m[key][0])
 	}
 	fmt.Fprintf(b, "\n")
 	return b.String()
 }
+
+func scanError(stage string, r httpclient.Response) *dbio.ScanError {
+	e := &dbio.ScanError{
+		Stage:    stage,
+		Category: r.ErrorCategory,
+		Message:  r.Error,
+	}
+	if e.Category == "" {
+		e.Category = httpclient.ErrOther
+	}
+	if e.Message == "" {
+		e.Message = "no response"
+	}
+	return e
+}
+
+const maxEvidenceMatch = 256
+
+// evidence trims the evidence list to the configured level:
+// "" or "off" drops it, "summary" keeps source, key and url, "full" keeps everything
+func evidence(level string, list []dbio.Evidence) []dbio.Evidence {
+	if level != "summary" && level != "full" {
+		return nil
+	}
+	out := make([]dbio.Evidence, 0, len(list))
+	for _, e := range list {
+		if level == "summary" {
+			e.Match = ""
+			e.Pattern = ""
+		} else {
+			e.Match = truncateUTF8(e.Match, maxEvidenceMatch)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// categoryFilter keeps apps in any of the listed categories, by id or name
+type categoryFilter map[string]bool
+
+func newCategoryFilter(names []string) categoryFilter {
+	f := make(categoryFilter, len(names))
+	for _, name := range names {
+		f[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	return f
+}
+
+func (f categoryFilter) allows(cats []dbio.Category) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, c := range cats {
+		if f[strconv.Itoa(c.ID)] || f[strings.ToLower(c.Name)] {
+			return true
+		}
+	}
+	return false
+}
+
+// moreSpecific reports whether version a says more than b, "1.18.0" over "1.18" over ""
+func moreSpecific(a string, b string) bool {
+	if a == "" || a == b {
+		return false
+	}
+	if b == "" {
+		return true
+	}
+	split := func(r rune) bool { return r == '.' || r == '-' || r == '_' || r == ' ' }
+	return len(strings.FieldsFunc(a, split)) > len(strings.FieldsFunc(b, split))
+}
+
+// combineConfidence treats confidences as independent, 50 and 50 give 75
+func combineConfidence(a int, b int) int {
+	return 100 - (100-a)*(100-b)/100
+}
+
+func unionEvidence(list []dbio.Evidence, add []dbio.Evidence) []dbio.Evidence {
+next:
+	for _, e := range add {
+		for _, seen := range list {
+			if seen == e {
+				continue next
+			}
+		}
+		list = append(list, e)
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package dblyzer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Diagnostic is one problem found in an apps.json file
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Source   string `json:"source,omitempty"`
+	App      string `json:"app,omitempty"`
+	Field    string `json:"field,omitempty"`
+	Problem  string `json:"problem"`
+}
+
+func (d Diagnostic) String() string {
+	where := d.App
+	if d.Field != "" {
+		where += "." + d.Field
+	}
+	if d.Source != "" {
+		where = d.Source + ": " + where
+	}
+	if where == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Problem)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Severity, where, d.Problem)
+}
+
+// HasErrors reports whether any diagnostic is an error, warnings don't count.
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateRules loads the rule sources like the scanner does and checks their
+// json, patterns, categories and implies/excludes references. Patterns that
+// only compile with the regexFallback engine are reported as warnings.
+func ValidateRules(sources []string, regexFallback string) []Diagnostic {
+	rc, err := newRegexCompiler(regexFallback)
+	if err != nil {
+		return []Diagnostic{{Severity: SeverityError, Problem: err.Error()}}
+	}
+	top, err := loadRawRules(sources)
+	if err != nil {
+		return []Diagnostic{{Severity: SeverityError, Problem: err.Error()}}
+	}
+	if len(top.Apps) == 0 {
+		return []Diagnostic{{Severity: SeverityError, Field: "apps", Problem: "no apps defined"}}
+	}
+
+	var diags []Diagnostic
+	add := func(severity, app, field, format string, a ...interface{}) {
+		diags = append(diags, Diagnostic{
+			Severity: severity,
+			Source:   top.Origin[app],
+			App:      app,
+			Field:    field,
+			Problem:  fmt.Sprintf(format, a...),
+		})
+	}
+
+	for id, raw := range top.Cats {
+		var c category
+		if err := json.Unmarshal(raw, &c); err != nil {
+			add(SeverityError, "", "categories."+id, "%s", err)
+		} else if c.Name == "" {
+			add(SeverityWarning, "", "categories."+id, "category has no name")
+		}
+	}
+
+	for name, raw := range top.Apps {
+		a, ok := decodeApp(name, raw, add)
+		if !ok {
+			continue
+		}
+
+		compileRegexes(rc, name, "html", a.HTML)
+		compileRegexes(rc, name, "script", a.Script)
+		compileRegexes(rc, name, "url", a.URL)
+		compileNamedRegexes(rc, name, "headers", a.Headers)
+		compileNamedRegexes(rc, name, "meta", a.Meta)
+		compileNamedRegexes(rc, name, "cookies", a.Cookies)
+
+		for _, cid := range a.Cats {
+			if _, ok := top.Cats[cid]; !ok {
+				add(SeverityError, name, "cats", "unknown category %s", cid)
+			}
+		}
+		for _, implied := range a.Implies {
+			if target := ruleName(implied); top.Apps[target] == nil {
+				add(SeverityError, name, "implies", "unknown app %q", target)
+			}
+		}
+		for _, excluded := range a.Excludes {
+			if target := ruleName(excluded); top.Apps[target] == nil {
+				add(SeverityError, name, "excludes", "unknown app %q", target)
+			}
+		}
+	}
+
+	for _, p := range rc.report.Fallback {
+		add(SeverityWarning, p.App, p.Field, "pattern %q needs the %s regex engine: %s", p.Pattern, p.Backend, p.Err)
+	}
+	for _, p := range rc.report.Failed {
+		add(SeverityError, p.App, p.Field, "pattern %q does not compile: %s", p.Pattern, p.Err)
+	}
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].App != diags[j].App {
+			return diags[i].App < diags[j].App
+		}
+		if diags[i].Field != diags[j].Field {
+			return diags[i].Field < diags[j].Field
+		}
+		return diags[i].Problem < diags[j].Problem
+	})
+	return diags
+}
+
+// decode one app field by field so a bad field is reported by name
+func decodeApp(name string, raw json.RawMessage, add func(severity, app, field, format string, a ...interface{})) (app, bool) {
+	var a app
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		add(SeverityError, name, "", "%s", err)
+		return a, false
+	}
+
+	targets := map[string]interface{}{
+		"cats":     &a.Cats,
+		"cookies":  &a.Cookies,
+		"headers":  &a.Headers,
+		"meta":     &a.Meta,
+		"html":     &a.HTML,
+		"script":   &a.Script,
+		"url":      &a.URL,
+		"website":  &a.Website,
+		"implies":  &a.Implies,
+		"excludes": &a.Excludes,
+	}
+	for field, value := range fields {
+		target, ok := targets[field]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(value, target); err != nil {
+			add(SeverityError, name, field, "%s", err)
+		}
+	}
+	if len(a.Cats) == 0 {
+		add(SeverityWarning, name, "cats", "app has no category")
+	}
+	return a, true
+}
+
+// strip attributes like "PHP\;confidence:50"
+func ruleName(s string) string {
+	return strings.Split(s, "\\;")[0]
+}
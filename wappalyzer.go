@@ -2,41 +2,53 @@ package dblyzer
 
 import (
 	"bytes"
+	"context"
+	"dblyzer/internal/dbio"
 	"dblyzer/internal/httpclient"
+	"dblyzer/internal/logger"
 	"encoding/json"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"net/http"
-	"os"
-	"regexp"
 	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
 )
 
 type appsDefinition struct {
 	Apps map[string]app      `json:"apps"`
 	Cats map[string]category `json:"categories"`
+
+	// sorted app names, apps are always checked in this order
+	names []string
 }
 type Results struct {
-	R []Result
-	Icon string
+	R     []Result
+	Icon  string
+	Rules string
+	URL   string
 }
 type Result struct {
 	icon       string
-	Categories []string
+	Categories []dbio.Category
 	AppName    string
 	Version    string
 	Implies    []string
+	Confidence int
+	Evidence   []dbio.Evidence
 }
 type category struct {
-	Name string `json:"name"`
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
 }
 
 type Match struct {
-	app     `json:"app"`
-	AppName string     `json:"appname"`
-	Matches [][]string `json:"matches"`
-	Version string     `json:"version"`
+	app        `json:"app"`
+	AppName    string          `json:"appname"`
+	Matches    [][]string      `json:"matches"`
+	Version    string          `json:"version"`
+	Confidence int             `json:"confidence"`
+	Evidence   []dbio.Evidence `json:"evidence"`
 }
 
 func (m *Match) updateVersion(version string) {
@@ -45,17 +57,29 @@ func (m *Match) updateVersion(version string) {
 	}
 }
 
+func (m *Match) addEvidence(source string, key string, url string, evidence []dbio.Evidence) {
+	for _, e := range evidence {
+		e.Source = source
+		e.Key = key
+		e.URL = url
+		m.Evidence = append(m.Evidence, e)
+		if logger.Enabled(logger.LevelDebug) {
+			logger.Debug("pattern matched", "app", m.AppName, "source", source, "key", key, "pattern", e.Pattern, "match", e.Match, "url", url)
+		}
+	}
+}
+
 type app struct {
-	Cats     stringArray       `json:"cats"`
-	CatNames []string          `json:"category_names"`
-	Cookies  map[string]string `json:"cookies"`
-	Headers  map[string]string `json:"headers"`
-	Meta     map[string]string `json:"meta"`
-	HTML     stringArray       `json:"html"`
-	Script   stringArray       `json:"script"`
-	URL      stringArray       `json:"url"`
-	Website  string            `json:"website"`
-	Implies  stringArray       `json:"implies"`
+	Cats     stringArray            `json:"cats"`
+	Cookies  map[string]stringArray `json:"cookies"`
+	Headers  map[string]stringArray `json:"headers"`
+	Meta     map[string]stringArray `json:"meta"`
+	HTML     stringArray            `json:"html"`
+	Script   stringArray            `json:"script"`
+	URL      stringArray            `json:"url"`
+	Website  string                 `json:"website"`
+	Implies  stringArray            `json:"implies"`
+	Excludes stringArray            `json:"excludes"`
 
 	hTMLRegex   []appRegexp `json:"-"`
 	scriptRegex []appRegexp `json:"-"`
@@ -63,9 +87,11 @@ type app struct {
 	headerRegex []appRegexp `json:"-"`
 	metaRegex   []appRegexp `json:"-"`
 	cookieRegex []appRegexp `json:"-"`
+
+	categories []dbio.Category `json:"-"`
 }
 
-func (app *app) findInHeaders(headers http.Header) (matches [][]string, version string) {
+func (app *app) findInHeaders(headers http.Header) (matches [][]string, version string, evidence []dbio.Evidence) {
 	var v string
 
 	for _, hre := range app.headerRegex {
@@ -77,13 +103,18 @@ func (app *app) findInHeaders(headers http.Header) (matches [][]string, version
 			if headerValue == "" {
 				continue
 			}
-			if m, version := findMatches(headerValue, []appRegexp{hre}); len(m) > 0 {
+			if m, version, ev := findMatches(headerValue, []appRegexp{hre}); len(m) > 0 {
 				matches = append(matches, m...)
 				v = version
+				for _, e := range ev {
+					e.Source = dbio.EvidenceHeader
+					e.Key = hk
+					evidence = append(evidence, e)
+				}
 			}
 		}
 	}
-	return matches, v
+	return matches, v, evidence
 }
 
 type stringArray []string
@@ -108,119 +139,122 @@ func (t *stringArray) UnmarshalJSON(data []byte) error {
 			*t = sa
 			return nil
 		}
-		fmt.Println(string(data))
-		return err
+		return fmt.Errorf("expected a string, []string or []int, got %s", data)
 	}
 	*t = stringArray{s}
 	return nil
 }
 
 type appRegexp struct {
-	Name    string
-	Regexp  *regexp.Regexp
-	Version string
+	Name       string
+	Regexp     matcher
+	Pattern    string
+	Version    string
+	Confidence int
 }
 
+// Wappalyzer matches responses against the rules. Analyze runs in the
+// caller's goroutine and is safe for concurrent use.
 type Wappalyzer struct {
-	appDefs *appsDefinition
-	in      chan input
-	out     chan []Result
-}
-
-type input struct {
-	r   httpclient.Response
-	res chan Results
+	busy     int64
+	rules    atomic.Value
+	reload   sync.Mutex
+	sources  []string
+	fallback string
+	// bounds concurrent analyses, nil when unbounded
+	slots chan struct{}
 }
 
-func newWappalyzer(filePath string, worker int) *Wappalyzer {
-	f, err := os.Open(filePath)
+// newWappalyzer compiles the rules, limit > 0 caps the analyses running at once
+func newWappalyzer(sources []string, regexFallback string, limit int) (*Wappalyzer, error) {
+	rs, err := compileRuleSet(sources, regexFallback)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-	defer f.Close()
-	var appDefs *appsDefinition
-	dec := json.NewDecoder(f)
-	if err = dec.Decode(&appDefs); err != nil {
-		panic(err.Error())
+	w := &Wappalyzer{sources: sources, fallback: regexFallback}
+	if limit > 0 {
+		w.slots = make(chan struct{}, limit)
 	}
+	w.rules.Store(rs)
+	return w, nil
+}
 
-	for key, value := range appDefs.Apps {
-
-		app := appDefs.Apps[key]
-
-		app.hTMLRegex = compileRegexes(value.HTML)
-		app.scriptRegex = compileRegexes(value.Script)
-		app.uRLRegex = compileRegexes(value.URL)
-
-		app.headerRegex = compileNamedRegexes(app.Headers)
-		app.metaRegex = compileNamedRegexes(app.Meta)
-		app.cookieRegex = compileNamedRegexes(app.Cookies)
-
-		app.CatNames = make([]string, 0)
-
-		for _, cid := range app.Cats {
-			if category, ok := appDefs.Cats[string(cid)]; ok && category.Name != "" {
-				app.CatNames = append(app.CatNames, category.Name)
-			}
-		}
+func (w *Wappalyzer) ruleSet() *ruleSet {
+	return w.rules.Load().(*ruleSet)
+}
 
-		appDefs.Apps[key] = app
+// Reload compiles the rule sources again and swaps them in atomically.
+// Analyses already running finish on the previous rule set.
+func (w *Wappalyzer) Reload() error {
+	w.reload.Lock()
+	defer w.reload.Unlock()
+	rs, err := compileRuleSet(w.sources, w.fallback)
+	if err != nil {
+		return err
 	}
-	w := &Wappalyzer{appDefs: appDefs, in: make(chan input, 100)}
+	w.rules.Store(rs)
+	return nil
+}
 
-	for i := 0; i < worker; i++ {
-		w.run()
-	}
+// RulesVersion is a hash of the rule set in use.
+func (w *Wappalyzer) RulesVersion() string {
+	return w.ruleSet().version
+}
 
-	return w
+// CompileReport lists the apps.json patterns the default regex engine rejected.
+func (w *Wappalyzer) CompileReport() CompileReport {
+	return w.ruleSet().report
 }
 
-func (w *Wappalyzer) run() {
-	go func() {
-		for {
-			select {
-			case r := <-w.in:
-				w.analyze(r)
-			}
-		}
-	}()
+// utilization is the number of analyses running and the limit, 0 when unbounded
+func (w *Wappalyzer) utilization() Queue {
+	return Queue{Len: int(atomic.LoadInt64(&w.busy)), Cap: cap(w.slots)}
 }
 
-func (w *Wappalyzer) Analyze(r httpclient.Response) Results {
-	res := make(chan Results)
-	w.in <- input{r, res}
-	return <-res
+// Analyze matches r against the rules. It returns ctx's error when ctx is
+// done before the analysis finished, no result is dropped otherwise.
+func (w *Wappalyzer) Analyze(ctx context.Context, r httpclient.Response) (Results, error) {
+	return w.analyze(ctx, w.ruleSet(), r)
 }
 
-func (w *Wappalyzer) analyze(r input) {
-	var results []Result
-	results = make([]Result, 0)
-	icon, matched := w.process(r.r)
-	for index := range matched {
-		result := Result{
-			Categories: nil,
-			AppName:    "",
-			Version:    "",
-			Implies:    nil,
+// analyze matches r against rs, the probes of one scan share a rule set
+// even when the rules are reloaded in between
+func (w *Wappalyzer) analyze(ctx context.Context, rs *ruleSet, r httpclient.Response) (Results, error) {
+	if w.slots != nil {
+		select {
+		case w.slots <- struct{}{}:
+			defer func() { <-w.slots }()
+		case <-ctx.Done():
+			return Results{}, ctx.Err()
 		}
-		result.Categories = matched[index].CatNames
-		result.AppName = matched[index].AppName
-		result.Version = matched[index].Version
-		result.Implies = matched[index].Implies
-		results = append(results, result)
 	}
-	select {
-	case r.res <- Results{
-		R:    results,
-		Icon: icon,
-	}:
-		return
-	case <-time.After(5 * time.Second):
-		return
+	atomic.AddInt64(&w.busy, 1)
+	defer atomic.AddInt64(&w.busy, -1)
+
+	icon, matched, err := w.process(ctx, rs.appDefs, r)
+	if err != nil {
+		return Results{}, err
+	}
+	results := make([]Result, 0, len(matched))
+	for _, m := range matched {
+		results = append(results, Result{
+			Categories: m.categories,
+			AppName:    m.AppName,
+			Version:    m.Version,
+			Implies:    m.Implies,
+			Confidence: m.Confidence,
+			Evidence:   m.Evidence,
+		})
 	}
+	return Results{
+		R:     results,
+		Icon:  icon,
+		Rules: rs.version,
+		URL:   r.URL,
+	}, nil
 }
 
-func (w *Wappalyzer) process(r httpclient.Response) (string, []Match) {
+func (w *Wappalyzer) process(ctx context.Context, appDefs *appsDefinition, r httpclient.Response) (string, []Match, error) {
 	var canParseBody = true
 	var icon = ""
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader([]byte(r.Text)))
@@ -235,8 +269,16 @@ func (w *Wappalyzer) process(r httpclient.Response) (string, []Match) {
 		cookiesMap[c.Name] = c.Value
 	}
 
+	if canParseBody {
+		icon = findIcon(doc)
+	}
+
 	// handle crawling
-	for Appname, app := range w.appDefs.Apps {
+	for i, Appname := range appDefs.names {
+		if i%64 == 0 && ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+		app := appDefs.Apps[Appname]
 		// TODO: Reduce complexity in this for-loop by functionalising out
 		// the sub-loops and checks.
 
@@ -248,15 +290,19 @@ func (w *Wappalyzer) process(r httpclient.Response) (string, []Match) {
 
 		// check uri
 
-		if m, v := findMatches(r.Path, app.uRLRegex); len(m) > 0 && r.StatusCode == 200 {
+		if m, v, ev := findMatches(r.Path, app.uRLRegex); len(m) > 0 && r.StatusCode == 200 {
 			findings.Matches = append(findings.Matches, m...)
 			findings.updateVersion(v)
+			findings.addEvidence(dbio.EvidenceURL, "", r.URL, ev)
 		}
 
 		// check response header
-		headerFindings, version := app.findInHeaders(r.Headers)
+		headerFindings, version, headerEvidence := app.findInHeaders(r.Headers)
 		findings.Matches = append(findings.Matches, headerFindings...)
 		findings.updateVersion(version)
+		for _, e := range headerEvidence {
+			findings.addEvidence(e.Source, e.Key, r.URL, []dbio.Evidence{e})
+		}
 
 		// check cookies
 		for _, c := range app.cookieRegex {
@@ -267,42 +313,34 @@ func (w *Wappalyzer) process(r httpclient.Response) (string, []Match) {
 				if c.Regexp != nil {
 
 					// only match single AppRegexp on this specific cookie
-					if m, v := findMatches(cookiesMap[c.Name], []appRegexp{c}); len(m) > 0 {
+					if m, v, ev := findMatches(cookiesMap[c.Name], []appRegexp{c}); len(m) > 0 {
 						findings.Matches = append(findings.Matches, m...)
 						findings.updateVersion(v)
+						findings.addEvidence(dbio.EvidenceCookie, c.Name, r.URL, ev)
 					}
 
 				} else {
 					findings.Matches = append(findings.Matches, []string{c.Name})
+					findings.addEvidence(dbio.EvidenceCookie, c.Name, r.URL, []dbio.Evidence{{Confidence: 100}})
 				}
 			}
 
 		}
 		if canParseBody {
-			// get icon
-
-			doc.Find("link").Each(func(i int, s *goquery.Selection) {
-				if script, exists := s.Attr("href"); exists {
-					if ic, exists := s.Attr("rel") ; exists {
-						if strings.ToLower(ic) == "icon" || strings.ToLower(ic) == "shortcut icon" {
-							icon = strings.Replace(script, "../", "/", -1)
-						}
-					}
-				}
-			})
-
 			// check raw html
-			if m, v := findMatches(r.Text, app.hTMLRegex); len(m) > 0 {
+			if m, v, ev := findMatches(r.Text, app.hTMLRegex); len(m) > 0 {
 				findings.Matches = append(findings.Matches, m...)
 				findings.updateVersion(v)
+				findings.addEvidence(dbio.EvidenceHTML, "", r.URL, ev)
 			}
 
 			// check script tags
 			doc.Find("script").Each(func(i int, s *goquery.Selection) {
 				if script, exists := s.Attr("src"); exists {
-					if m, v := findMatches(script, app.scriptRegex); len(m) > 0 {
+					if m, v, ev := findMatches(script, app.scriptRegex); len(m) > 0 {
 						findings.Matches = append(findings.Matches, m...)
 						findings.updateVersion(v)
+						findings.addEvidence(dbio.EvidenceScript, script, r.URL, ev)
 					}
 				}
 			})
@@ -312,34 +350,62 @@ func (w *Wappalyzer) process(r httpclient.Response) (string, []Match) {
 				selector := fmt.Sprintf("meta[name='%s']", h.Name)
 				doc.Find(selector).Each(func(i int, s *goquery.Selection) {
 					content, _ := s.Attr("content")
-					if m, v := findMatches(content, []appRegexp{h}); len(m) > 0 {
+					if m, v, ev := findMatches(content, []appRegexp{h}); len(m) > 0 {
 						findings.Matches = append(findings.Matches, m...)
 						findings.updateVersion(v)
+						findings.addEvidence(dbio.EvidenceMeta, h.Name, r.URL, ev)
 					}
 				})
 			}
 		}
 
 		if len(findings.Matches) > 0 {
+			for _, e := range findings.Evidence {
+				findings.Confidence += e.Confidence
+			}
+			if findings.Confidence > 100 {
+				findings.Confidence = 100
+			}
 			apps = append(apps, findings)
 
 			// handle implies
 			for _, implies := range app.Implies {
-				for implyAppname, implyApp := range w.appDefs.Apps {
-					if implies != implyAppname {
-						continue
-					}
-
-					f2 := Match{
-						app:     implyApp,
-						AppName: implyAppname,
-						Matches: make([][]string, 0),
-					}
-					apps = append(apps, f2)
+				implyAppname, _, confidence := parsePattern(implies)
+				implyApp, ok := appDefs.Apps[implyAppname]
+				if !ok {
+					continue
+				}
+				if confidence > findings.Confidence {
+					confidence = findings.Confidence
 				}
 
+				f2 := Match{
+					app:        implyApp,
+					AppName:    implyAppname,
+					Matches:    make([][]string, 0),
+					Confidence: confidence,
+				}
+				f2.addEvidence(dbio.EvidenceImplied, Appname, r.URL, []dbio.Evidence{{Confidence: confidence}})
+				apps = append(apps, f2)
 			}
 		}
 	}
-	return icon, apps
+	return icon, apps, nil
+}
+
+// findIcon returns the first icon link of the page, so the choice does not
+// depend on how many icons a page declares
+func findIcon(doc *goquery.Document) string {
+	var icon string
+	doc.Find("link[href]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		rel, _ := s.Attr("rel")
+		rel = strings.ToLower(strings.TrimSpace(rel))
+		if rel != "icon" && rel != "shortcut icon" {
+			return true
+		}
+		href, _ := s.Attr("href")
+		icon = strings.Replace(href, "../", "/", -1)
+		return false
+	})
+	return icon
 }
@@ -0,0 +1,240 @@
+package dblyzer
+
+import (
+	"context"
+	"dblyzer/internal/httpclient"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testResponse() httpclient.Response {
+	return httpclient.Response{
+		StatusCode: 200,
+		Success:    true,
+		URL:        "http://127.0.0.1/",
+		Path:       "/",
+		Headers:    http.Header{"Server": {"nginx/1.18.0"}, "X-Powered-By": {"PHP/7.4.3"}},
+		Text:       testPage,
+	}
+}
+
+func testWappalyzer(t *testing.T, rules string, limit int) *Wappalyzer {
+	w, err := newWappalyzer([]string{rules}, "backtrack", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestAnalyzeConcurrent(t *testing.T) {
+	w := testWappalyzer(t, testRules, 4)
+	want, err := w.Analyze(context.Background(), testResponse())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want.R) == 0 {
+		t.Fatal("no apps found")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				got, err := w.Analyze(context.Background(), testResponse())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got %+v, want %+v", got, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if u := w.utilization(); u.Len != 0 || u.Cap != 4 {
+		t.Errorf("utilization %+v after the analyses", u)
+	}
+}
+
+// cancelAfter is done from its n+1th Err call on, so process notices it at a
+// given app instead of before the first one
+type cancelAfter struct {
+	context.Context
+	n     int32
+	calls int32
+}
+
+func (c *cancelAfter) Err() error {
+	if atomic.AddInt32(&c.calls, 1) > c.n {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestAnalyzeCancelledMidProcess(t *testing.T) {
+	// enough apps for process to check ctx more than once
+	apps := make(map[string]interface{})
+	for i := 0; i < 200; i++ {
+		apps[fmt.Sprintf("App%03d", i)] = map[string]interface{}{"headers": map[string]string{"Server": "nginx"}}
+	}
+	b, err := json.Marshal(map[string]interface{}{"apps": apps, "categories": map[string]interface{}{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := filepath.Join(t.TempDir(), "apps.json")
+	if err := ioutil.WriteFile(rules, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	w := testWappalyzer(t, rules, 0)
+
+	ctx := &cancelAfter{Context: context.Background(), n: 1}
+	res, err := w.Analyze(ctx, testResponse())
+	if err != context.Canceled {
+		t.Fatalf("err %v, want context.Canceled", err)
+	}
+	if ctx.calls < 2 {
+		t.Errorf("ctx checked %d times, want the cancellation seen mid process", ctx.calls)
+	}
+	if len(res.R) != 0 {
+		t.Errorf("cancelled analysis returned %d apps", len(res.R))
+	}
+
+	res, err = w.Analyze(context.Background(), testResponse())
+	if err != nil || len(res.R) != 200 {
+		t.Errorf("got %d apps, err %v, want 200", len(res.R), err)
+	}
+}
+
+func TestAnalyzeWaitsForSlot(t *testing.T) {
+	w := testWappalyzer(t, testRules, 1)
+	w.slots <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := w.Analyze(ctx, testResponse()); err != context.DeadlineExceeded {
+		t.Fatalf("err %v while all slots are taken, want context.DeadlineExceeded", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Analyze(context.Background(), testResponse())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("analysis ran without a free slot, err %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-w.slots
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("analysis still waiting after the slot was freed")
+	}
+}
+
+// writeRules atomically replaces path with testdata/apps.json, plus an Extra
+// app matching nginx when extra is set
+func writeRules(t *testing.T, path string, extra bool) {
+	b, err := ioutil.ReadFile(testRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &rules); err != nil {
+		t.Fatal(err)
+	}
+	if extra {
+		rules["apps"]["Extra"] = map[string]interface{}{"headers": map[string]string{"Server": "nginx"}}
+	}
+	if b, err = json.Marshal(rules); err != nil {
+		t.Fatal(err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadWhileAnalyzing(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "apps.json")
+	writeRules(t, rules, true)
+	w := testWappalyzer(t, rules, 0)
+	withExtra := w.RulesVersion()
+	writeRules(t, rules, false)
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	without := w.RulesVersion()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				res, err := w.Analyze(context.Background(), testResponse())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				extra := false
+				for _, r := range res.R {
+					extra = extra || r.AppName == "Extra"
+				}
+				// every analysis runs on one whole rule set
+				if (res.Rules == withExtra) != extra || (res.Rules != withExtra && res.Rules != without) {
+					t.Errorf("rules %s found Extra %v", res.Rules, extra)
+					return
+				}
+			}
+		}()
+	}
+	// reloads racing each other while the file flips between the two sets
+	var reloads sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		reloads.Add(1)
+		go func() {
+			defer reloads.Done()
+			for j := 0; j < 10; j++ {
+				if err := w.Reload(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	for j := 0; j < 10; j++ {
+		writeRules(t, rules, j%2 == 0)
+		if err := w.Reload(); err != nil {
+			t.Error(err)
+		}
+	}
+	reloads.Wait()
+	close(stop)
+	wg.Wait()
+}